@@ -0,0 +1,233 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sync"
+)
+
+const (
+	gridCellDeg      = 0.05
+	maxCellsPerFence = 4096
+)
+
+type gridCell struct {
+	Lat int
+	Lon int
+}
+
+// geofenceCache keeps every active geofence in memory together with a
+// uniform grid over their bounding boxes, so location updates only test
+// the few geofences whose cells contain the point. Geofences too large for
+// the grid are kept in a separate list and always tested.
+type geofenceCache struct {
+	mu        sync.RWMutex
+	stale     bool
+	geofences map[string]*Geofence
+	grid      map[gridCell][]*Geofence
+	large     []*Geofence
+	parents   map[string]string
+}
+
+var geofenceIndex = &geofenceCache{stale: true}
+
+func (c *geofenceCache) invalidate() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+}
+
+func (c *geofenceCache) ensureLoaded() {
+	c.mu.RLock()
+	stale := c.stale
+	c.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stale {
+		return
+	}
+	if err := c.rebuild(); err != nil {
+		log.Println("Error loading geofence cache:", err)
+		return
+	}
+	c.stale = false
+}
+
+func (c *geofenceCache) rebuild() error {
+	rows, err := db.Query("SELECT " + geofenceColumns + " FROM geofences WHERE status = 'active'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	geofences := make(map[string]*Geofence)
+	grid := make(map[gridCell][]*Geofence)
+	var large []*Geofence
+
+	for rows.Next() {
+		g, err := scanGeofence(rows)
+		if err != nil {
+			log.Println("Error scanning geofence:", err)
+			continue
+		}
+
+		gf := &g
+		geofences[gf.ID] = gf
+
+		cells, ok := cellsForGeofence(gf)
+		if !ok {
+			large = append(large, gf)
+			continue
+		}
+		for _, cell := range cells {
+			grid[cell] = append(grid[cell], gf)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	parents, err := loadGroupParents()
+	if err != nil {
+		return err
+	}
+
+	c.geofences, c.grid, c.large, c.parents = geofences, grid, large, parents
+	log.Printf("Geofence cache loaded: %d active geofences", len(geofences))
+	return nil
+}
+
+func (c *geofenceCache) get(id string) (*Geofence, bool) {
+	c.ensureLoaded()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	g, ok := c.geofences[id]
+	return g, ok
+}
+
+// groupPath returns the group followed by its ancestors, nearest first.
+func (c *geofenceCache) groupPath(groupID string) []string {
+	c.ensureLoaded()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var path []string
+	seen := make(map[string]bool)
+	for groupID != "" && !seen[groupID] {
+		seen[groupID] = true
+		path = append(path, groupID)
+		groupID = c.parents[groupID]
+	}
+	return path
+}
+
+// candidates returns the geofences whose bounding box may contain the point.
+func (c *geofenceCache) candidates(lat, lon float64) []*Geofence {
+	c.ensureLoaded()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cell := cellFor(lat, lon)
+	result := make([]*Geofence, 0, len(c.grid[cell])+len(c.large))
+	result = append(result, c.grid[cell]...)
+	result = append(result, c.large...)
+	return result
+}
+
+// near returns the geofences whose bounding box may lie within the given
+// distance of the point.
+func (c *geofenceCache) near(lat, lon, meters float64) []*Geofence {
+	return c.intersecting([]boundingBox{boundsOfPoints([][2]float64{{lat, lon}}).expand(meters)})
+}
+
+// intersecting returns the geofences whose bounding box may intersect one
+// of the given boxes.
+func (c *geofenceCache) intersecting(boxes []boundingBox) []*Geofence {
+	c.ensureLoaded()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cells, ok := cellsForBoxes(boxes)
+	if !ok {
+		result := make([]*Geofence, 0, len(c.geofences))
+		for _, g := range c.geofences {
+			result = append(result, g)
+		}
+		return result
+	}
+
+	seen := make(map[string]bool)
+	result := append([]*Geofence(nil), c.large...)
+	for _, cell := range cells {
+		for _, g := range c.grid[cell] {
+			if !seen[g.ID] {
+				seen[g.ID] = true
+				result = append(result, g)
+			}
+		}
+	}
+	return result
+}
+
+func (c *geofenceCache) containing(lat, lon float64) []*Geofence {
+	var result []*Geofence
+	for _, g := range c.candidates(lat, lon) {
+		if g.contains(lat, lon) {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// Longitude cell indexes wrap around the antimeridian so that boxes
+// extending past ±180 land in the cells of the other hemisphere.
+var lonCells = int(math.Round(360 / gridCellDeg))
+
+func wrapLonCell(i int) int {
+	return ((i+lonCells/2)%lonCells+lonCells)%lonCells - lonCells/2
+}
+
+func cellFor(lat, lon float64) gridCell {
+	return gridCell{
+		Lat: int(math.Floor(lat / gridCellDeg)),
+		Lon: wrapLonCell(int(math.Floor(lon / gridCellDeg))),
+	}
+}
+
+func cellsForGeofence(g *Geofence) ([]gridCell, bool) {
+	return cellsForBoxes(g.boxes())
+}
+
+func cellsForBoxes(boxes []boundingBox) ([]gridCell, bool) {
+	seen := make(map[gridCell]bool)
+	var cells []gridCell
+
+	for _, b := range boxes {
+		loLat, hiLat := int(math.Floor(b.MinLat/gridCellDeg)), int(math.Floor(b.MaxLat/gridCellDeg))
+		loLon, hiLon := int(math.Floor(b.MinLon/gridCellDeg)), int(math.Floor(b.MaxLon/gridCellDeg))
+
+		count := (hiLat - loLat + 1) * (hiLon - loLon + 1)
+		if count <= 0 || len(cells)+count > maxCellsPerFence {
+			return nil, false
+		}
+
+		for la := loLat; la <= hiLat; la++ {
+			for ln := loLon; ln <= hiLon; ln++ {
+				cell := gridCell{Lat: la, Lon: wrapLonCell(ln)}
+				if !seen[cell] {
+					seen[cell] = true
+					cells = append(cells, cell)
+				}
+			}
+		}
+	}
+	return cells, true
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestCellForWrapsAtDateline(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b [2]float64
+		same bool
+	}{
+		{"180 and -180", [2]float64{0, 180}, [2]float64{0, -180}, true},
+		{"179.99 and -179.99", [2]float64{0, 179.99}, [2]float64{0, -179.99}, false},
+		{"same point", [2]float64{12.3, -179.5}, [2]float64{12.3, -179.5}, true},
+	}
+	for _, tt := range tests {
+		if got := cellFor(tt.a[0], tt.a[1]) == cellFor(tt.b[0], tt.b[1]); got != tt.same {
+			t.Errorf("%s: same cell = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+
+	if c := cellFor(0, 180); c.Lon < -lonCells/2 || c.Lon >= lonCells/2 {
+		t.Errorf("cellFor(0, 180) = %+v, longitude index out of range", c)
+	}
+}
+
+func TestCellsForBoxesAcrossDateline(t *testing.T) {
+	tests := []struct {
+		name   string
+		box    boundingBox
+		points [][2]float64
+	}{
+		{"box extending past 180", boundingBox{-0.1, 179.9, 0.1, 180.1}, [][2]float64{{0, 179.95}, {0, 180}, {0, -180}, {0, -179.95}}},
+		{"box extending past -180", boundingBox{-0.1, -180.1, 0.1, -179.9}, [][2]float64{{0, 179.95}, {0, 180}, {0, -180}, {0, -179.95}}},
+	}
+	for _, tt := range tests {
+		cells, ok := cellsForBoxes([]boundingBox{tt.box})
+		if !ok {
+			t.Fatalf("%s: box unexpectedly too large for the grid", tt.name)
+		}
+		set := make(map[gridCell]bool)
+		for _, c := range cells {
+			set[c] = true
+		}
+		for _, p := range tt.points {
+			if !set[cellFor(p[0], p[1])] {
+				t.Errorf("%s: cell of %v missing from %v", tt.name, p, cells)
+			}
+		}
+		if set[cellFor(0, 0)] {
+			t.Errorf("%s: cells include the prime meridian", tt.name)
+		}
+	}
+
+	if _, ok := cellsForBoxes([]boundingBox{{-10, 170, 10, 190}}); ok {
+		t.Error("a box of 20° by 20° should be too large for the grid")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Category describes a kind of geofence. Geofences reference it by ID in
+// their category field.
+type Category struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Severity         string `json:"severity"`
+	Color            string `json:"color"`
+	DefaultEventType string `json:"default_event_type,omitempty"`
+	StrictOverlap    bool   `json:"strict_overlap"`
+	CreatedAt        string `json:"created_at"`
+}
+
+var severities = map[string]bool{
+	"info":     true,
+	"low":      true,
+	"medium":   true,
+	"high":     true,
+	"critical": true,
+}
+
+var (
+	categoryIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,50}$`)
+	colorPattern      = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+const categoryColumns = `id, name, severity, color, default_event_type, strict_overlap, created_at`
+
+// seedCategories adds the built-in categories and any category already in
+// use by a geofence, leaving existing entries untouched.
+func seedCategories() {
+	_, err := db.Exec(`
+	INSERT INTO categories (id, name, severity, color) VALUES
+		('delivery_zone', 'Delivery zone', 'low', '#2e7d32'),
+		('restricted_zone', 'Restricted zone', 'high', '#c62828'),
+		('toll_zone', 'Toll zone', 'medium', '#ef6c00'),
+		('customer_area', 'Customer area', 'info', '#1565c0')
+	ON CONFLICT (id) DO NOTHING;
+
+	INSERT INTO categories (id, name)
+	SELECT DISTINCT category, category FROM geofences
+	ON CONFLICT (id) DO NOTHING;
+	`)
+	if err != nil {
+		log.Println("Error seeding categories:", err)
+	}
+}
+
+func scanCategory(row rowScanner) (Category, error) {
+	var c Category
+	var defaultEventType sql.NullString
+	err := row.Scan(&c.ID, &c.Name, &c.Severity, &c.Color, &defaultEventType, &c.StrictOverlap, &c.CreatedAt)
+	c.DefaultEventType = defaultEventType.String
+	return c, err
+}
+
+func loadCategory(id string) (Category, error) {
+	return scanCategory(db.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = $1", id))
+}
+
+func categoryExists(id string) bool {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		log.Println("Error querying categories:", err)
+	}
+	return exists
+}
+
+func strictOverlapCategories() map[string]bool {
+	strict := make(map[string]bool)
+	rows, err := db.Query(`SELECT id FROM categories WHERE strict_overlap`)
+	if err != nil {
+		log.Println("Error querying categories:", err)
+		return strict
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			strict[id] = true
+		}
+	}
+	return strict
+}
+
+func validateCategory(c *Category) error {
+	var errs validationErrors
+
+	if !categoryIDPattern.MatchString(c.ID) {
+		errs.add("id", "must be 1-50 lowercase letters, digits or underscores")
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		c.Name = c.ID
+	}
+	if c.Severity == "" {
+		c.Severity = "medium"
+	}
+	if !severities[c.Severity] {
+		errs.add("severity", "must be one of info, low, medium, high, critical")
+	}
+	if c.Color == "" {
+		c.Color = "#607d8b"
+	}
+	if !colorPattern.MatchString(c.Color) {
+		errs.add("color", "must be a hex color such as #c62828")
+	}
+	switch c.DefaultEventType {
+	case "", "entry", "exit", "both":
+	default:
+		errs.add("default_event_type", "must be one of entry, exit, both")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func createCategory(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	var req Category
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCategory(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": err,
+		}, startTime)
+		return
+	}
+
+	res, err := db.Exec(
+		`INSERT INTO categories (id, name, severity, color, default_event_type, strict_overlap)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO NOTHING`,
+		req.ID, req.Name, req.Severity, req.Color, nullString(req.DefaultEventType), req.StrictOverlap,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, fmt.Sprintf("category %q already exists", req.ID), http.StatusConflict)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"category": req,
+	}, startTime)
+}
+
+func getCategories(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	rows, err := db.Query("SELECT " + categoryColumns + " FROM categories ORDER BY id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			log.Println("Error scanning category:", err)
+			continue
+		}
+		categories = append(categories, c)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"categories": categories,
+	}, startTime)
+}
+
+func getCategory(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	c, err := loadCategory(chi.URLParam(r, "categoryID"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"category": c,
+	}, startTime)
+}
+
+func updateCategory(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	existing, err := loadCategory(chi.URLParam(r, "categoryID"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// PUT replaces the category; PATCH applies the given fields on top of it.
+	c := Category{}
+	if r.Method == http.MethodPatch {
+		c = existing
+	}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.ID, c.CreatedAt = existing.ID, existing.CreatedAt
+
+	if err := validateCategory(&c); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": err,
+		}, startTime)
+		return
+	}
+
+	_, err = db.Exec(
+		`UPDATE categories SET name = $2, severity = $3, color = $4, default_event_type = $5, strict_overlap = $6
+		WHERE id = $1`,
+		c.ID, c.Name, c.Severity, c.Color, nullString(c.DefaultEventType), c.StrictOverlap,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"category": c,
+	}, startTime)
+}
+
+// deleteCategory removes a category that no geofence uses any more.
+func deleteCategory(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "categoryID")
+
+	if _, err := loadCategory(id); err == sql.ErrNoRows {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var inUse int
+	err := db.QueryRow(`SELECT COUNT(*) FROM geofences WHERE category = $1 AND status <> 'deleted'`, id).Scan(&inUse)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if inUse > 0 {
+		http.Error(w, fmt.Sprintf("category is used by %d geofences", inUse), http.StatusConflict)
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM categories WHERE id = $1`, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": "deleted",
+	}, startTime)
+}
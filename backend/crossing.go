@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	defaultCrossingMaxGap = 5 * time.Minute
+
+	// crossingSampleSpacingM is the distance between the points tested
+	// along the segment between two reports. Long segments are sampled more
+	// coarsely so that no segment takes more than maxCrossingSamples tests
+	// per geofence; the boundary crossings found are then refined by
+	// bisection.
+	crossingSampleSpacingM = 5.0
+	maxCrossingSamples     = 2000
+	crossingBisections     = 30
+)
+
+// crossingMaxGap bounds the time between two reports for the straight line
+// between them to be treated as the path driven. After a longer gap the
+// vehicle may have gone anywhere, so no pass-throughs are inferred.
+var crossingMaxGap = crossingMaxGapFromEnv()
+
+func crossingMaxGapFromEnv() time.Duration {
+	if v := os.Getenv("CROSSING_MAX_GAP"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Invalid CROSSING_MAX_GAP %q, using %s", v, defaultCrossingMaxGap)
+	}
+	return defaultCrossingMaxGap
+}
+
+// crossingEvent is an entry into or exit from a geofence interpolated on
+// the segment between two reports.
+type crossingEvent struct {
+	geofenceID string
+	eventType  string
+	lat, lon   float64
+	at         time.Time
+}
+
+// checkPassThroughs looks for geofences the vehicle drove straight through
+// between the previous report and the current one, without either report
+// falling inside them. Each pass is emitted as an entry and an exit flagged
+// as a pass-through, at the interpolated points and times where the segment
+// crosses the boundary, together with the group entry and exit of groups
+// the vehicle was in at neither report. Geofence state is left untouched,
+// since the vehicle ends up outside again.
+func checkPassThroughs(vehicleID string, prev, cur evaluatedPoint, current []CurrentGeofence) {
+	gap := cur.at.Sub(prev.at)
+	if crossingMaxGap == 0 || gap < 0 || gap > crossingMaxGap {
+		return
+	}
+
+	prevInside, err := insideGeofenceIDs(vehicleID)
+	if err != nil {
+		log.Println("Error loading geofence state:", err)
+		return
+	}
+	skip := make(map[string]bool)
+	for geofenceID := range prevInside {
+		skip[geofenceID] = true
+	}
+	for _, g := range current {
+		skip[g.GeofenceID] = true
+	}
+
+	inside := skip
+	for _, e := range segmentCrossings(prev, cur, skip) {
+		triggerPassThrough(vehicleID, e)
+
+		next := make(map[string]bool, len(inside)+1)
+		for geofenceID := range inside {
+			next[geofenceID] = true
+		}
+		if e.eventType == "entry" {
+			next[e.geofenceID] = true
+		} else {
+			delete(next, e.geofenceID)
+		}
+		checkGroupTransitions(vehicleID, inside, next, e.lat, e.lon, e.at.UTC().Format(time.RFC3339Nano), true)
+		inside = next
+	}
+}
+
+// segmentCrossings returns, in time order, the boundary crossings of the
+// geofences not in skip along the straight segment from prev to cur.
+func segmentCrossings(prev, cur evaluatedPoint, skip map[string]bool) []crossingEvent {
+	gap := cur.at.Sub(prev.at)
+	path := unwrapPath([][2]float64{{prev.lat, prev.lon}, {cur.lat, cur.lon}})
+	length := haversineMeters(prev.lat, prev.lon, cur.lat, cur.lon)
+	samples := int(math.Ceil(length / crossingSampleSpacingM))
+	if samples < 2 {
+		return nil
+	}
+	if samples > maxCrossingSamples {
+		samples = maxCrossingSamples
+	}
+
+	pointAt := func(f float64) (float64, float64, time.Time) {
+		lat := path[0][0] + f*(path[1][0]-path[0][0])
+		lon := normalizeLonDelta(path[0][1] + f*(path[1][1]-path[0][1]))
+		return lat, lon, prev.at.Add(time.Duration(f * float64(gap)))
+	}
+
+	var events []crossingEvent
+	for _, g := range geofenceIndex.intersecting([]boundingBox{boundsOfPoints(path)}) {
+		if skip[g.ID] {
+			continue
+		}
+
+		inside := func(f float64) bool {
+			lat, lon, at := pointAt(f)
+			return g.Schedule.activeAt(at) && g.insideWithHysteresis(lat, lon, false)
+		}
+		crossing := func(out, in float64, eventType string) crossingEvent {
+			for i := 0; i < crossingBisections; i++ {
+				mid := (out + in) / 2
+				if inside(mid) {
+					in = mid
+				} else {
+					out = mid
+				}
+			}
+			lat, lon, at := pointAt(in)
+			return crossingEvent{geofenceID: g.ID, eventType: eventType, lat: lat, lon: lon, at: at}
+		}
+
+		wasInside := false
+		for i := 1; i < samples; i++ {
+			f := float64(i) / float64(samples)
+			isInside := inside(f)
+			if isInside == wasInside {
+				continue
+			}
+			last := float64(i-1) / float64(samples)
+			if isInside {
+				events = append(events, crossing(last, f, "entry"))
+			} else {
+				events = append(events, crossing(f, last, "exit"))
+			}
+			wasInside = isInside
+		}
+		if wasInside {
+			// The current report lies outside, so the pass ends before it.
+			events = append(events, crossing(1, float64(samples-1)/float64(samples), "exit"))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+	return events
+}
+
+func triggerPassThrough(vehicleID string, e crossingEvent) {
+	if !alertConfigured(vehicleID, e.geofenceID, e.eventType) {
+		return
+	}
+
+	timestamp := e.at.UTC().Format(time.RFC3339Nano)
+	recordViolation(vehicleID, e.geofenceID, "", e.eventType, e.lat, e.lon, timestamp, true)
+
+	alert := newAlert(vehicleID, e.geofenceID, e.eventType, e.lat, e.lon, timestamp)
+	alert["pass_through"] = true
+	recordAlertHistory(vehicleID, e.geofenceID, "", e.eventType, e.lat, e.lon, timestamp, true)
+
+	hub.broadcast <- alert
+}
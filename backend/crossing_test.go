@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// useGeofences replaces the geofence index with one holding the given
+// geofences for the duration of the test.
+func useGeofences(t *testing.T, geofences ...*Geofence) {
+	saved := geofenceIndex
+	index := &geofenceCache{geofences: make(map[string]*Geofence)}
+	for _, g := range geofences {
+		index.geofences[g.ID] = g
+		index.large = append(index.large, g)
+	}
+	geofenceIndex = index
+	t.Cleanup(func() { geofenceIndex = saved })
+}
+
+func TestSegmentCrossings(t *testing.T) {
+	start := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+
+	box := &Geofence{ID: "box", Shape: shapePolygon, Coordinates: square(0, 0, 0.001, 0.001)}
+	off := &Geofence{
+		ID:          "off",
+		Shape:       shapePolygon,
+		Coordinates: square(0, 0, 0.001, 0.001),
+		Schedule: &GeofenceSchedule{
+			Timezone:   "UTC",
+			Exceptions: []ScheduleException{{Date: "2026-01-05", Active: false}},
+		},
+	}
+	circle := &Geofence{ID: "circle", Shape: shapeCircle, Center: &[2]float64{0, 180}, RadiusM: 1000}
+	radiusDeg := 1000 / earthRadiusM * 180 / math.Pi
+
+	type event struct {
+		eventType string
+		lon       float64
+		at        time.Duration
+	}
+	tests := []struct {
+		name      string
+		geofence  *Geofence
+		prev, cur [2]float64
+		skip      map[string]bool
+		want      []event
+	}{
+		{
+			"through a square", box, [2]float64{0.0005, -0.001}, [2]float64{0.0005, 0.002}, nil,
+			[]event{{"entry", 0, 20 * time.Second}, {"exit", 0.001, 40 * time.Second}},
+		},
+		{
+			"through a circle on the dateline", circle, [2]float64{0, 179.98}, [2]float64{0, -179.98}, nil,
+			[]event{
+				{"entry", 180 - radiusDeg, time.Duration((0.02 - radiusDeg) / 0.04 * float64(time.Minute))},
+				{"exit", -180 + radiusDeg, time.Duration((0.02 + radiusDeg) / 0.04 * float64(time.Minute))},
+			},
+		},
+		{"skipped", box, [2]float64{0.0005, -0.001}, [2]float64{0.0005, 0.002}, map[string]bool{"box": true}, nil},
+		{"missing the geofence", box, [2]float64{0.002, -0.001}, [2]float64{0.002, 0.002}, nil, nil},
+		{"outside the schedule", off, [2]float64{0.0005, -0.001}, [2]float64{0.0005, 0.002}, nil, nil},
+		{"too short to sample", box, [2]float64{0.0005, -0.00001}, [2]float64{0.0005, 0.00001}, nil, nil},
+	}
+	for _, tt := range tests {
+		useGeofences(t, tt.geofence)
+		got := segmentCrossings(
+			evaluatedPoint{tt.prev[0], tt.prev[1], start},
+			evaluatedPoint{tt.cur[0], tt.cur[1], end},
+			tt.skip,
+		)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d crossings %+v, want %d", tt.name, len(got), got, len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			e := got[i]
+			if e.geofenceID != tt.geofence.ID || e.eventType != w.eventType {
+				t.Errorf("%s: crossing %d is %s of %s, want %s of %s", tt.name, i, e.eventType, e.geofenceID, w.eventType, tt.geofence.ID)
+			}
+			if math.Abs(e.lon-w.lon) > 1e-6 {
+				t.Errorf("%s: %s at longitude %v, want %v", tt.name, w.eventType, e.lon, w.lon)
+			}
+			if d := e.at.Sub(start.Add(w.at)); d < -time.Millisecond || d > time.Millisecond {
+				t.Errorf("%s: %s at %v, want %v", tt.name, w.eventType, e.at.Sub(start), w.at)
+			}
+		}
+	}
+}
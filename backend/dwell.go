@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const defaultDwellCheckInterval = 15 * time.Second
+
+func dwellCheckInterval() time.Duration {
+	if v := os.Getenv("DWELL_CHECK_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid DWELL_CHECK_INTERVAL %q, using %s", v, defaultDwellCheckInterval)
+	}
+	return defaultDwellCheckInterval
+}
+
+// runDwellEvaluator periodically fires dwell alerts for vehicles that have
+// stayed inside a geofence longer than a configured threshold. It runs
+// independently of location updates so a parked vehicle that stops
+// reporting still raises the alert.
+func runDwellEvaluator(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		evaluateDwellAlerts(time.Now().UTC())
+	}
+}
+
+func evaluateDwellAlerts(now time.Time) {
+	rows, err := db.Query(
+		`SELECT DISTINCT s.vehicle_id, s.geofence_id, s.entered_at, ac.dwell_seconds
+		 FROM vehicle_geofence_state s
+		 JOIN alert_configs ac ON ac.geofence_id = s.geofence_id
+		   AND (ac.vehicle_id = s.vehicle_id OR ac.vehicle_id IS NULL)
+		 WHERE s.status = 'inside' AND s.entered_at IS NOT NULL
+		   AND ac.status = 'active' AND ac.event_type = 'dwell'
+		   AND ac.dwell_seconds > s.dwell_alerted_seconds
+		   AND s.entered_at + ac.dwell_seconds * INTERVAL '1 second' <= $1
+		 ORDER BY ac.dwell_seconds`,
+		sqlTimestamp(now),
+	)
+	if err != nil {
+		log.Println("Error querying dwell alerts:", err)
+		return
+	}
+
+	type dwell struct {
+		vehicleID, geofenceID string
+		enteredAt             time.Time
+		seconds               int
+	}
+	var due []dwell
+	for rows.Next() {
+		var d dwell
+		if err := rows.Scan(&d.vehicleID, &d.geofenceID, &d.enteredAt, &d.seconds); err != nil {
+			log.Println("Error scanning dwell alert:", err)
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	for _, d := range due {
+		if g, ok := geofenceIndex.get(d.geofenceID); !ok || !g.Schedule.activeAt(now) {
+			continue
+		}
+
+		var lat, lon float64
+		err := db.QueryRow(
+			`SELECT latitude, longitude FROM locations
+			WHERE vehicle_id = $1 ORDER BY timestamp DESC LIMIT 1`,
+			d.vehicleID,
+		).Scan(&lat, &lon)
+		if err != nil {
+			log.Println("Error loading location for dwell alert:", err)
+			continue
+		}
+
+		timestamp := d.enteredAt.Add(time.Duration(d.seconds) * time.Second).Format(time.RFC3339)
+		recordViolation(d.vehicleID, d.geofenceID, "", "dwell", lat, lon, timestamp, false)
+		triggerAlert(d.vehicleID, d.geofenceID, "dwell", lat, lon, timestamp)
+
+		_, err = db.Exec(
+			`UPDATE vehicle_geofence_state SET dwell_alerted_seconds = $3
+			 WHERE vehicle_id = $1 AND geofence_id = $2 AND dwell_alerted_seconds < $3`,
+			d.vehicleID, d.geofenceID, d.seconds,
+		)
+		if err != nil {
+			log.Println("Error updating dwell state:", err)
+		}
+	}
+}
@@ -3,43 +3,454 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
+	"time"
+
+	"github.com/google/uuid"
 )
 
-func checkGeofences(vehicleID string, lat float64, lon float64) []CurrentGeofence {
-	var currentGeofences []CurrentGeofence
+const (
+	shapePolygon      = "polygon"
+	shapeMultiPolygon = "multipolygon"
+	shapeCircle       = "circle"
+	shapeCorridor     = "corridor"
+)
 
-	rows, err := db.Query(
-		`SELECT id, name, category, coordinates FROM geofences WHERE status = 'active'`,
+const geofenceColumns = `id, name, description, shape, coordinates, holes, polygons, center_latitude, center_longitude, radius_m, width_m, hysteresis_m, schedule, group_id, category,
+	area_m2, perimeter_m, centroid_latitude, centroid_longitude, bbox_min_lat, bbox_min_lon, bbox_max_lat, bbox_max_lon, tags, metadata, status, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGeofence(row rowScanner) (Geofence, error) {
+	var g Geofence
+	var coordStr string
+	var holesStr, polygonsStr, scheduleStr, groupID sql.NullString
+	var centerLat, centerLon, radius, width sql.NullFloat64
+	var metrics [8]sql.NullFloat64
+	var tagsJSON, metaJSON []byte
+
+	err := row.Scan(&g.ID, &g.Name, &g.Description, &g.Shape, &coordStr, &holesStr, &polygonsStr,
+		&centerLat, &centerLon, &radius, &width, &g.HysteresisM, &scheduleStr, &groupID, &g.Category,
+		&metrics[0], &metrics[1], &metrics[2], &metrics[3], &metrics[4], &metrics[5], &metrics[6], &metrics[7],
+		&tagsJSON, &metaJSON, &g.Status, &g.CreatedAt)
+	if err != nil {
+		return g, err
+	}
+
+	if err := json.Unmarshal([]byte(coordStr), &g.Coordinates); err != nil {
+		return g, fmt.Errorf("geofence %s: invalid coordinates: %w", g.ID, err)
+	}
+	if holesStr.Valid {
+		if err := json.Unmarshal([]byte(holesStr.String), &g.Holes); err != nil {
+			return g, fmt.Errorf("geofence %s: invalid holes: %w", g.ID, err)
+		}
+	}
+	if polygonsStr.Valid {
+		if err := json.Unmarshal([]byte(polygonsStr.String), &g.Polygons); err != nil {
+			return g, fmt.Errorf("geofence %s: invalid polygons: %w", g.ID, err)
+		}
+	}
+	if scheduleStr.Valid {
+		if err := json.Unmarshal([]byte(scheduleStr.String), &g.Schedule); err != nil {
+			return g, fmt.Errorf("geofence %s: invalid schedule: %w", g.ID, err)
+		}
+	}
+	g.GroupID = groupID.String
+	if centerLat.Valid && centerLon.Valid {
+		g.Center = &[2]float64{centerLat.Float64, centerLon.Float64}
+	}
+	g.RadiusM = radius.Float64
+	g.WidthM = width.Float64
+	g.CrossesAntimeridian = g.crossesAntimeridian()
+	setMetrics(&g, metrics)
+	g.Tags, g.Metadata = parseTagsAndMetadata(tagsJSON, metaJSON)
+
+	return g, nil
+}
+
+// geofenceValues prepares the stored form of a geofence's attributes, in
+// the column order used by insertGeofence and updateGeofence. Fields that do
+// not apply to the geofence's shape are cleared and the metrics recomputed.
+func geofenceValues(g *Geofence) []interface{} {
+	switch g.Shape {
+	case shapeMultiPolygon:
+		g.Coordinates, g.Holes = g.Polygons[0][0], nil
+		g.Center, g.RadiusM, g.WidthM = nil, 0, 0
+	case shapeCircle:
+		g.Coordinates, g.Holes, g.Polygons = [][2]float64{}, nil, nil
+		g.WidthM = 0
+	case shapeCorridor:
+		g.Holes, g.Polygons = nil, nil
+		g.Center, g.RadiusM = nil, 0
+	default:
+		g.Polygons = nil
+		g.Center, g.RadiusM, g.WidthM = nil, 0, 0
+	}
+	if g.Coordinates == nil {
+		g.Coordinates = [][2]float64{}
+	}
+	coordJSON, _ := json.Marshal(g.Coordinates)
+	g.computeMetrics()
+
+	var holesJSON, polygonsJSON, scheduleJSON, groupID sql.NullString
+	if g.GroupID != "" {
+		groupID = sql.NullString{String: g.GroupID, Valid: true}
+	}
+	if g.Schedule != nil {
+		b, _ := json.Marshal(g.Schedule)
+		scheduleJSON = sql.NullString{String: string(b), Valid: true}
+	}
+	if len(g.Holes) > 0 {
+		b, _ := json.Marshal(g.Holes)
+		holesJSON = sql.NullString{String: string(b), Valid: true}
+	}
+	if len(g.Polygons) > 0 {
+		b, _ := json.Marshal(g.Polygons)
+		polygonsJSON = sql.NullString{String: string(b), Valid: true}
+	}
+
+	var centerLat, centerLon, radius, width sql.NullFloat64
+	if g.Center != nil {
+		centerLat = sql.NullFloat64{Float64: g.Center[0], Valid: true}
+		centerLon = sql.NullFloat64{Float64: g.Center[1], Valid: true}
+	}
+	if g.RadiusM > 0 {
+		radius = sql.NullFloat64{Float64: g.RadiusM, Valid: true}
+	}
+	if g.WidthM > 0 {
+		width = sql.NullFloat64{Float64: g.WidthM, Valid: true}
+	}
+
+	values := []interface{}{
+		g.Name, g.Description, g.Shape, string(coordJSON), holesJSON, polygonsJSON,
+		centerLat, centerLon, radius, width, g.HysteresisM, scheduleJSON, groupID, g.Category,
+	}
+	tags, metadata := tagsAndMetadataValues(g.Tags, g.Metadata)
+	values = append(values, metricsValues(g)...)
+	return append(values, tags, metadata)
+}
+
+func insertGeofence(g *Geofence) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := append([]interface{}{g.ID}, geofenceValues(g)...)
+	_, err = tx.Exec(
+		`INSERT INTO geofences (id, name, description, shape, coordinates, holes, polygons, center_latitude, center_longitude, radius_m, width_m, hysteresis_m, schedule, group_id, category,
+		area_m2, perimeter_m, centroid_latitude, centroid_longitude, bbox_min_lat, bbox_min_lon, bbox_max_lat, bbox_max_lon, tags, metadata, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+		$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, 'active')`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	if err := updateGeofenceGeography(tx, g); err != nil {
+		return err
+	}
+	g.Status = "active"
+	if err := recordGeofenceVersion(tx, g, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	geofenceIndex.invalidate()
+	return nil
+}
+
+func loadGeofence(id string) (Geofence, error) {
+	return scanGeofence(db.QueryRow(
+		"SELECT "+geofenceColumns+" FROM geofences WHERE id = $1 AND status <> 'deleted'",
+		id,
+	))
+}
+
+// saveGeofence stores the new attributes and status of an existing
+// geofence. Vehicle state is cleared in the same transaction when the
+// geofence stops being active, so no vehicle remains "inside" a zone that
+// is no longer evaluated and a later reactivation starts from a clean
+// slate; vehicles that were inside a group only through it get a group
+// exit.
+func saveGeofence(g *Geofence) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldGroupID sql.NullString
+	if err := tx.QueryRow(`SELECT group_id FROM geofences WHERE id = $1 FOR UPDATE`, g.ID).Scan(&oldGroupID); err != nil {
+		return err
+	}
+
+	args := append([]interface{}{g.ID, g.Status}, geofenceValues(g)...)
+	_, err = tx.Exec(
+		`UPDATE geofences SET status = $2, name = $3, description = $4, shape = $5, coordinates = $6,
+		holes = $7, polygons = $8, center_latitude = $9, center_longitude = $10, radius_m = $11,
+		width_m = $12, hysteresis_m = $13, schedule = $14, group_id = $15, category = $16,
+		area_m2 = $17, perimeter_m = $18, centroid_latitude = $19, centroid_longitude = $20,
+		bbox_min_lat = $21, bbox_min_lon = $22, bbox_max_lat = $23, bbox_max_lon = $24,
+		tags = $25, metadata = $26
+		WHERE id = $1`,
+		args...,
 	)
 	if err != nil {
-		log.Println("Error querying geofences:", err)
-		return currentGeofences
+		return err
+	}
+	if err := updateGeofenceGeography(tx, g); err != nil {
+		return err
+	}
+	if err := recordGeofenceVersion(tx, g, time.Now()); err != nil {
+		return err
+	}
+	var cleared []clearedState
+	if g.Status != "active" {
+		if cleared, err = clearGeofenceState(tx, g.ID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	geofenceIndex.invalidate()
+	emitGroupExits(g.ID, oldGroupID.String, cleared)
+	return nil
+}
+
+func softDeleteGeofence(id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var groupID sql.NullString
+	if err := tx.QueryRow(
+		`UPDATE geofences SET status = 'deleted' WHERE id = $1 RETURNING group_id`, id,
+	).Scan(&groupID); err != nil {
+		return err
+	}
+	if err := closeGeofenceVersion(tx, id, time.Now()); err != nil {
+		return err
+	}
+	cleared, err := clearGeofenceState(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	geofenceIndex.invalidate()
+	emitGroupExits(id, groupID.String, cleared)
+	return nil
+}
+
+// sqlTimestamp formats t for the TIMESTAMP (without time zone) columns,
+// which hold UTC wall-clock times.
+func sqlTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.999999")
+}
+
+// clearedState is a vehicle that was inside a geofence when its state was
+// cleared, with the vehicle's last evaluated location.
+type clearedState struct {
+	vehicleID string
+	lat, lon  float64
+}
+
+// clearGeofenceState deletes every vehicle's state for the geofence and
+// returns the vehicles that were inside it.
+func clearGeofenceState(tx *sql.Tx, geofenceID string) ([]clearedState, error) {
+	rows, err := tx.Query(
+		`WITH cleared AS (
+			DELETE FROM vehicle_geofence_state WHERE geofence_id = $1 RETURNING vehicle_id, status
+		)
+		SELECT c.vehicle_id, v.last_evaluated_latitude, v.last_evaluated_longitude
+		FROM cleared c LEFT JOIN vehicles v ON v.id = c.vehicle_id
+		WHERE c.status = 'inside'`,
+		geofenceID,
+	)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
+	var cleared []clearedState
 	for rows.Next() {
-		var id, name, category, coordStr string
-		if err := rows.Scan(&id, &name, &category, &coordStr); err != nil {
-			log.Println("Error scanning geofence:", err)
-			continue
+		var c clearedState
+		var lat, lon sql.NullFloat64
+		if err := rows.Scan(&c.vehicleID, &lat, &lon); err != nil {
+			return nil, err
 		}
+		c.lat, c.lon = lat.Float64, lon.Float64
+		cleared = append(cleared, c)
+	}
+	return cleared, rows.Err()
+}
 
-		var coordinates [][2]float64
-		if err := json.Unmarshal([]byte(coordStr), &coordinates); err != nil {
-			log.Println("Error unmarshaling coordinates:", err)
-			continue
+func (g *Geofence) contains(lat, lon float64) bool {
+	switch g.Shape {
+	case shapeCircle:
+		return g.Center != nil && haversineMeters(lat, lon, g.Center[0], g.Center[1]) <= g.RadiusM
+	case shapeCorridor:
+		return len(g.Coordinates) > 0 && pointToPolylineMeters(lat, lon, g.Coordinates) <= g.WidthM
+	default:
+		for _, rings := range g.polygonRings() {
+			if isPointInPolygonWithHoles(lat, lon, rings) {
+				return true
+			}
 		}
+		return false
+	}
+}
 
-		if isPointInPolygon(lat, lon, coordinates) {
-			currentGeofences = append(currentGeofences, CurrentGeofence{
-				GeofenceID:   id,
-				GeofenceName: name,
-				Category:     category,
-				Status:       "inside",
-			})
+// distanceToBoundary returns the geodesic distance in meters from the point
+// to the nearest edge of the geofence, whether the point is inside or not.
+func (g *Geofence) distanceToBoundary(lat, lon float64) float64 {
+	switch g.Shape {
+	case shapeCircle:
+		if g.Center == nil {
+			return math.Inf(1)
+		}
+		return math.Abs(haversineMeters(lat, lon, g.Center[0], g.Center[1]) - g.RadiusM)
+	case shapeCorridor:
+		return math.Abs(pointToPolylineMeters(lat, lon, g.Coordinates) - g.WidthM)
+	default:
+		best := math.Inf(1)
+		for _, rings := range g.polygonRings() {
+			for _, ring := range rings {
+				if len(ring) > 1 {
+					best = math.Min(best, pointToPolylineMeters(lat, lon, ring))
+				}
+			}
 		}
+		return best
+	}
+}
+
+// signedDistance is negative inside the geofence and positive outside.
+func (g *Geofence) signedDistance(lat, lon float64) float64 {
+	d := g.distanceToBoundary(lat, lon)
+	if g.contains(lat, lon) {
+		return -d
+	}
+	return d
+}
+
+// insideWithHysteresis applies the geofence's hysteresis buffer: a vehicle
+// outside must be at least hysteresis_m inside the boundary to enter, and a
+// vehicle inside must be at least hysteresis_m beyond it to exit.
+func (g *Geofence) insideWithHysteresis(lat, lon float64, wasInside bool) bool {
+	if g.HysteresisM <= 0 {
+		return g.contains(lat, lon)
+	}
+
+	d := g.signedDistance(lat, lon)
+	if wasInside {
+		return d < g.HysteresisM
+	}
+	return d <= -g.HysteresisM
+}
+
+// boxes returns one bounding box per polygon, or a single box for circles
+// and corridors. Boxes of shapes crossing the antimeridian extend past ±180.
+func (g *Geofence) boxes() []boundingBox {
+	switch g.Shape {
+	case shapeCircle:
+		if g.Center == nil {
+			return nil
+		}
+		return []boundingBox{boundsOfPoints([][2]float64{*g.Center}).expand(g.RadiusM)}
+	case shapeCorridor:
+		return []boundingBox{boundsOfPoints(unwrapPath(g.Coordinates)).expand(g.WidthM)}
+	default:
+		var boxes []boundingBox
+		for _, rings := range g.polygonRings() {
+			if len(rings) > 0 {
+				planar, _ := planarRings(rings)
+				boxes = append(boxes, boundsOfPoints(planar[0]))
+			}
+		}
+		return boxes
+	}
+}
+
+func (g *Geofence) crossesAntimeridian() bool {
+	switch g.Shape {
+	case shapeCircle, shapeCorridor:
+		b := g.bounds()
+		return b.MinLon < -180 || b.MaxLon > 180
+	default:
+		for _, rings := range g.polygonRings() {
+			if _, crosses := planarRings(rings); crosses {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (g *Geofence) bounds() boundingBox {
+	boxes := g.boxes()
+	if len(boxes) == 0 {
+		return boundingBox{}
+	}
+
+	b := boxes[0]
+	for _, other := range boxes[1:] {
+		b = b.union(other)
+	}
+	return b
+}
+
+// polygonRings normalizes polygon and multipolygon geofences into a list of
+// polygons, each given as its outer ring followed by any holes.
+func (g *Geofence) polygonRings() [][][][2]float64 {
+	if g.Shape == shapeMultiPolygon {
+		return g.Polygons
+	}
+	if len(g.Coordinates) == 0 {
+		return nil
+	}
+	rings := append([][][2]float64{g.Coordinates}, g.Holes...)
+	return [][][][2]float64{rings}
+}
+
+// parseTimestamp reads an ISO 8601 location timestamp, falling back to the
+// current time when it cannot be parsed.
+func parseTimestamp(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+func checkGeofences(vehicleID string, lat float64, lon float64, at time.Time) []CurrentGeofence {
+	var currentGeofences []CurrentGeofence
+
+	for _, g := range geofencesContaining(lat, lon) {
+		if !g.Schedule.activeAt(at) {
+			continue
+		}
+
+		currentGeofences = append(currentGeofences, CurrentGeofence{
+			GeofenceID:          g.ID,
+			GeofenceName:        g.Name,
+			Category:            g.Category,
+			Status:              "inside",
+			DistanceToBoundaryM: math.Round(g.distanceToBoundary(lat, lon)*100) / 100,
+		})
 	}
 
 	return currentGeofences
@@ -70,84 +481,164 @@ func isPointInPolygon(lat float64, lon float64, polygon [][2]float64) bool {
 	return inside
 }
 
-func getPreviousState(vehicleID, geofenceID string) string {
-	var state string
-	err := db.QueryRow(
-		`SELECT status FROM vehicle_geofence_state
-		 WHERE vehicle_id = $1 AND geofence_id = $2`,
-		vehicleID, geofenceID,
-	).Scan(&state)
+func isPointInPolygonWithHoles(lat float64, lon float64, rings [][][2]float64) bool {
+	if len(rings) == 0 || len(rings[0]) == 0 {
+		return false
+	}
 
-	if err == sql.ErrNoRows {
-		return "outside"
+	rings, crosses := planarRings(rings)
+	if crosses {
+		lon = shiftLonInto(lon, boundsOfPoints(rings[0]))
+	}
+
+	if !isPointInPolygon(lat, lon, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if len(hole) > 0 && isPointInPolygon(lat, lon, hole) {
+			return false
+		}
 	}
-	return state
+	return true
 }
 
-func updateGeofenceState(vehicleID, geofenceID, state string) {
-	db.Exec(
-		`INSERT INTO vehicle_geofence_state (vehicle_id, geofence_id, status)
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT (vehicle_id, geofence_id)
-		 DO UPDATE SET status = $3, updated_at = NOW()`,
-		vehicleID, geofenceID, state,
+func insideGeofenceIDs(vehicleID string) (map[string]bool, error) {
+	rows, err := db.Query(
+		`SELECT geofence_id FROM vehicle_geofence_state
+		 WHERE vehicle_id = $1 AND status = 'inside'`,
+		vehicleID,
 	)
-}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func checkAndTriggerAlerts(vehicleID string, lat, lon float64, timestamp string) {
-	current := checkGeofences(vehicleID, lat, lon)
+	inside := make(map[string]bool)
+	for rows.Next() {
+		var geofenceID string
+		if err := rows.Scan(&geofenceID); err != nil {
+			return nil, err
+		}
+		inside[geofenceID] = true
+	}
+	return inside, rows.Err()
+}
 
-	currentMap := make(map[string]bool)
-	for _, g := range current {
-		currentMap[g.GeofenceID] = true
+func updateGeofenceState(vehicleID, geofenceID, state, timestamp string) {
+	var enteredAt sql.NullString
+	if state == "inside" {
+		enteredAt = sql.NullString{String: sqlTimestamp(parseTimestamp(timestamp)), Valid: true}
 	}
 
-	rows, err := db.Query(
-		`SELECT id, geofence_id, event_type
-		 FROM alert_configs
-		 WHERE vehicle_id = $1 AND status = 'active'`,
-		vehicleID,
+	_, err := db.Exec(
+		`INSERT INTO vehicle_geofence_state (vehicle_id, geofence_id, status, entered_at, dwell_alerted_seconds)
+		 VALUES ($1, $2, $3, $4, 0)
+		 ON CONFLICT (vehicle_id, geofence_id)
+		 DO UPDATE SET status = $3, entered_at = $4, dwell_alerted_seconds = 0, updated_at = NOW()`,
+		vehicleID, geofenceID, state, enteredAt,
 	)
 	if err != nil {
-		log.Println(err)
+		log.Println("Error updating geofence state:", err)
+	}
+}
+
+func checkAndTriggerAlerts(vehicleID string, lat, lon float64, timestamp string, current []CurrentGeofence) {
+	prevInside, err := insideGeofenceIDs(vehicleID)
+	if err != nil {
+		log.Println("Error loading geofence state:", err)
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var alertID, geofenceID, eventType string
-		rows.Scan(&alertID, &geofenceID, &eventType)
+	candidates := make(map[string]bool)
+	for _, g := range current {
+		candidates[g.GeofenceID] = true
+	}
+	for geofenceID := range prevInside {
+		candidates[geofenceID] = true
+	}
+
+	nowInside := make(map[string]bool)
+	for geofenceID := range prevInside {
+		nowInside[geofenceID] = true
+	}
+
+	at := parseTimestamp(timestamp)
+	for geofenceID := range candidates {
+		g, ok := geofenceIndex.get(geofenceID)
+		if !ok || !g.Schedule.activeAt(at) {
+			continue
+		}
 
-		prevState := getPreviousState(vehicleID, geofenceID)
-		currState := "outside"
-		if currentMap[geofenceID] {
-			currState = "inside"
+		wasInside := prevInside[geofenceID]
+		isInside := g.insideWithHysteresis(lat, lon, wasInside)
+		if wasInside == isInside {
+			continue
 		}
 
-		if prevState == "outside" && currState == "inside" && eventType == "entry" {
-			recordViolation(vehicleID, geofenceID, "entry", lat, lon, timestamp)
-			triggerAlert(vehicleID, geofenceID, "entry", lat, lon, timestamp)
+		eventType, state := "exit", "outside"
+		if isInside {
+			eventType, state = "entry", "inside"
+		}
+		updateGeofenceState(vehicleID, geofenceID, state, timestamp)
+		if isInside {
+			nowInside[geofenceID] = true
+		} else {
+			delete(nowInside, geofenceID)
 		}
 
-		if prevState == "inside" && currState == "outside" && eventType == "exit" {
-			recordViolation(vehicleID, geofenceID, "exit", lat, lon, timestamp)
-			triggerAlert(vehicleID, geofenceID, "exit", lat, lon, timestamp)
+		if alertConfigured(vehicleID, geofenceID, eventType) {
+			recordViolation(vehicleID, geofenceID, "", eventType, lat, lon, timestamp, false)
+			triggerAlert(vehicleID, geofenceID, eventType, lat, lon, timestamp)
 		}
+	}
+
+	checkGroupTransitions(vehicleID, prevInside, nowInside, lat, lon, timestamp, false)
+}
 
-		updateGeofenceState(vehicleID, geofenceID, currState)
+// alertConfigured reports whether an alert rule covers the event. A
+// geofence without any alert configuration of its own falls back to the
+// default_event_type of its category.
+func alertConfigured(vehicleID, geofenceID, eventType string) bool {
+	var exists bool
+	err := db.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM alert_configs
+			WHERE geofence_id = $1 AND status = 'active'
+			AND (vehicle_id = $2 OR vehicle_id IS NULL)
+			AND (event_type = $3 OR event_type = 'both')
+		) OR (
+			NOT EXISTS (SELECT 1 FROM alert_configs WHERE geofence_id = $1 AND status = 'active')
+			AND EXISTS (
+				SELECT 1 FROM geofences g JOIN categories c ON c.id = g.category
+				WHERE g.id = $1 AND (c.default_event_type = $3 OR c.default_event_type = 'both')
+			)
+		)`,
+		geofenceID, vehicleID, eventType,
+	).Scan(&exists)
+	if err != nil {
+		log.Println("Error querying alert configs:", err)
 	}
+	return exists
 }
 
-func recordViolation(vehicleID string, geofenceID string, eventType string, lat float64, lon float64, timestamp string) {
+// recordViolation stores an event for the geofence. Group-level events
+// also carry the group and reference the member geofence that caused them.
+func recordViolation(vehicleID string, geofenceID string, groupID string, eventType string, lat float64, lon float64, timestamp string, passThrough bool) {
 	var vehNum, geoName string
 	db.QueryRow(`SELECT vehicle_number FROM vehicles WHERE id = $1`, vehicleID).Scan(&vehNum)
 	db.QueryRow(`SELECT name FROM geofences WHERE id = $1`, geofenceID).Scan(&geoName)
 
-	violID := "viol_" + randomID()
+	var group sql.NullString
+	if groupID != "" {
+		group = sql.NullString{String: groupID, Valid: true}
+	}
+
+	violID := "viol_" + uuid.New().String()
 	_, err := db.Exec(
-		`INSERT INTO violations (id, vehicle_id, geofence_id, event_type, latitude, longitude, timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		violID, vehicleID, geofenceID, eventType, lat, lon, timestamp,
+		`INSERT INTO violations (id, vehicle_id, geofence_id, group_id, event_type, latitude, longitude, timestamp, pass_through, geofence_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+			(SELECT version FROM geofence_versions WHERE geofence_id = $3 AND valid_to IS NULL))`,
+		violID, vehicleID, geofenceID, group, eventType, lat, lon, timestamp, passThrough,
 	)
 
 	if err != nil {
@@ -155,69 +646,70 @@ func recordViolation(vehicleID string, geofenceID string, eventType string, lat
 	}
 }
 
+// triggerAlert records and broadcasts an event that an alert rule covers;
+// callers check the rules first.
 func triggerAlert(vehicleID string, geofenceID string, eventType string, lat float64, lon float64, timestamp string) {
-	var configs []map[string]interface{}
-
-	rows, err := db.Query(
-		`SELECT id FROM alert_configs
-		WHERE geofence_id = $1 AND status = 'active'
-		AND (vehicle_id = $2 OR vehicle_id IS NULL)
-		AND (event_type = $3 OR event_type = 'both')`,
-		geofenceID, vehicleID, eventType,
-	)
-	if err != nil {
-		log.Println("Error querying alert configs:", err)
-		return
-	}
-	defer rows.Close()
-
-	if rows.Next() {
-		var veh Vehicle
-		var geo Geofence
-
-		db.QueryRow(`SELECT id, vehicle_number, driver_name, phone FROM vehicles WHERE id = $1`, vehicleID).Scan(&veh.ID, &veh.VehicleNumber, &veh.DriverName, &veh.Phone)
-		db.QueryRow(`SELECT id, name, category FROM geofences WHERE id = $1`, geofenceID).Scan(&geo.ID, &geo.Name, &geo.Category)
-
-		alert := map[string]interface{}{
-			"event_id":   "evt_" + randomID(),
-			"event_type": eventType,
-			"timestamp":  timestamp,
-			"vehicle": map[string]string{
-				"vehicle_id":     veh.ID,
-				"vehicle_number": veh.VehicleNumber,
-				"driver_name":    veh.DriverName,
-			},
-			"geofence": map[string]string{
-				"geofence_id":   geo.ID,
-				"geofence_name": geo.Name,
-				"category":      geo.Category,
-			},
-			"location": map[string]float64{
-				"latitude":  lat,
-				"longitude": lon,
-			},
-		}
-
-		alertHistID := "ah_" + randomID()
-		_, err := db.Exec(
-			`INSERT INTO alert_history (id, geofence_id, vehicle_id, event_type, latitude, longitude, timestamp)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-			alertHistID, geofenceID, vehicleID, eventType, lat, lon, timestamp,
-		)
-		if err != nil {
-			log.Println("Error recording alert history:", err)
-		}
+	alert := newAlert(vehicleID, geofenceID, eventType, lat, lon, timestamp)
+	recordAlertHistory(vehicleID, geofenceID, "", eventType, lat, lon, timestamp, false)
 
-		configs = append(configs, alert)
+	hub.broadcast <- alert
+}
 
-		hub.broadcast <- alert
+func newAlert(vehicleID string, geofenceID string, eventType string, lat float64, lon float64, timestamp string) map[string]interface{} {
+	var veh Vehicle
+	var geo Geofence
+	var vehTags, vehMeta, geoTags, geoMeta []byte
+
+	db.QueryRow(`SELECT id, vehicle_number, driver_name, phone, tags, metadata FROM vehicles WHERE id = $1`, vehicleID).Scan(&veh.ID, &veh.VehicleNumber, &veh.DriverName, &veh.Phone, &vehTags, &vehMeta)
+	db.QueryRow(`SELECT id, name, category, tags, metadata FROM geofences WHERE id = $1`, geofenceID).Scan(&geo.ID, &geo.Name, &geo.Category, &geoTags, &geoMeta)
+	category, _ := loadCategory(geo.Category)
+	veh.Tags, veh.Metadata = parseTagsAndMetadata(vehTags, vehMeta)
+	geo.Tags, geo.Metadata = parseTagsAndMetadata(geoTags, geoMeta)
+
+	return map[string]interface{}{
+		"event_id":   "evt_" + uuid.New().String(),
+		"event_type": eventType,
+		"timestamp":  timestamp,
+		"vehicle": map[string]interface{}{
+			"vehicle_id":     veh.ID,
+			"vehicle_number": veh.VehicleNumber,
+			"driver_name":    veh.DriverName,
+			"tags":           orEmptyTags(veh.Tags),
+			"metadata":       orEmptyMetadata(veh.Metadata),
+		},
+		"geofence": map[string]interface{}{
+			"geofence_id":   geo.ID,
+			"geofence_name": geo.Name,
+			"category":      geo.Category,
+			"tags":          orEmptyTags(geo.Tags),
+			"metadata":      orEmptyMetadata(geo.Metadata),
+		},
+		"category": map[string]string{
+			"id":       geo.Category,
+			"name":     category.Name,
+			"severity": category.Severity,
+			"color":    category.Color,
+		},
+		"location": map[string]float64{
+			"latitude":  lat,
+			"longitude": lon,
+		},
 	}
 }
 
-func randomID() string {
-	b := make([]byte, 8)
-	for i := range b {
-		b[i] = "0123456789abcdef"[i%16]
+func recordAlertHistory(vehicleID, geofenceID, groupID, eventType string, lat, lon float64, timestamp string, passThrough bool) {
+	var group sql.NullString
+	if groupID != "" {
+		group = sql.NullString{String: groupID, Valid: true}
+	}
+
+	alertHistID := "ah_" + uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO alert_history (id, geofence_id, group_id, vehicle_id, event_type, latitude, longitude, timestamp, pass_through)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		alertHistID, geofenceID, group, vehicleID, eventType, lat, lon, timestamp, passThrough,
+	)
+	if err != nil {
+		log.Println("Error recording alert history:", err)
 	}
-	return string(b)
 }
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+// square returns a closed counter-clockwise ring with corners (lat0, lon0)
+// and (lat1, lon1).
+func square(lat0, lon0, lat1, lon1 float64) [][2]float64 {
+	return [][2]float64{{lat0, lon0}, {lat0, lon1}, {lat1, lon1}, {lat1, lon0}, {lat0, lon0}}
+}
+
+func TestPolygonWithHolesContains(t *testing.T) {
+	g := &Geofence{
+		Shape:       shapePolygon,
+		Coordinates: square(0, 0, 10, 10),
+		Holes:       [][][2]float64{square(4, 4, 6, 6)},
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     bool
+	}{
+		{"inside shell", 2, 2, true},
+		{"inside hole", 5, 5, false},
+		{"between hole and shell", 5, 7, true},
+		{"outside shell", 11, 5, false},
+	}
+	for _, tt := range tests {
+		if got := g.contains(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("%s: contains(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
+
+func TestMultiPolygonContains(t *testing.T) {
+	g := &Geofence{
+		Shape: shapeMultiPolygon,
+		Polygons: [][][][2]float64{
+			{square(0, 0, 2, 2)},
+			{square(5, 5, 8, 8), square(6, 6, 7, 7)},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     bool
+	}{
+		{"first polygon", 1, 1, true},
+		{"second polygon", 5.5, 5.5, true},
+		{"hole of second polygon", 6.5, 6.5, false},
+		{"between polygons", 3.5, 3.5, false},
+	}
+	for _, tt := range tests {
+		if got := g.contains(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("%s: contains(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
+
+func TestValidateHoles(t *testing.T) {
+	shell := square(0, 0, 10, 10)
+
+	tests := []struct {
+		name    string
+		hole    [][2]float64
+		wantErr bool
+	}{
+		{"inside", square(4, 4, 6, 6), false},
+		{"outside", square(12, 12, 14, 14), true},
+		{"crossing the shell", square(8, 8, 12, 12), true},
+	}
+	for _, tt := range tests {
+		var errs validationErrors
+		validateHoles(&errs, shell, [][][2]float64{tt.hole}, "holes", 0)
+		if got := len(errs) > 0; got != tt.wantErr {
+			t.Errorf("%s: errors = %v, want error %v", tt.name, errs, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GeoJSON positions are [longitude, latitude]; geofences store
+// [latitude, longitude], so every conversion below swaps the axes.
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func importGeofences(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	repair := r.URL.Query().Get("repair") == "true"
+	var fc geoJSONFeatureCollection
+
+	if err := json.NewDecoder(r.Body).Decode(&fc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if fc.Type != "FeatureCollection" {
+		http.Error(w, "Body must be a GeoJSON FeatureCollection", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(fc.Features))
+	imported := 0
+	for i, f := range fc.Features {
+		result := map[string]interface{}{"index": i}
+
+		g, err := geofenceFromGeoJSON(f)
+		if err == nil {
+			err = validateGeofence(&g, repair)
+		}
+		var overlaps []GeofenceOverlap
+		if err == nil {
+			overlaps, err = checkOverlaps(&g)
+		}
+		if err == nil {
+			g.ID = "geo_" + uuid.New().String()
+			err = insertGeofence(&g)
+		}
+
+		result["name"] = g.Name
+		if errs, ok := err.(validationErrors); ok {
+			result["status"] = "failed"
+			result["errors"] = errs
+		} else if err != nil {
+			result["status"] = "failed"
+			result["error"] = err.Error()
+		} else {
+			result["status"] = "created"
+			result["id"] = g.ID
+			imported++
+		}
+		if len(overlaps) > 0 {
+			result["overlaps"] = overlaps
+		}
+		results = append(results, result)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"imported": imported,
+		"failed":   len(fc.Features) - imported,
+		"results":  results,
+	}, startTime)
+}
+
+func geofencesToGeoJSON(geofences []Geofence) map[string]interface{} {
+	features := make([]geoJSONFeature, 0, len(geofences))
+	for _, g := range geofences {
+		features = append(features, geofenceToGeoJSON(g))
+	}
+
+	return map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	}
+}
+
+func geofenceToGeoJSON(g Geofence) geoJSONFeature {
+	props := map[string]interface{}{
+		"name":        g.Name,
+		"description": g.Description,
+		"category":    g.Category,
+		"status":      g.Status,
+		"shape":       g.Shape,
+		"area_m2":     g.AreaM2,
+		"perimeter_m": g.PerimeterM,
+		"created_at":  g.CreatedAt,
+	}
+	if len(g.Tags) > 0 {
+		props["tags"] = g.Tags
+	}
+	if len(g.Metadata) > 0 {
+		props["metadata"] = g.Metadata
+	}
+
+	var geomType string
+	var coords interface{}
+	switch g.Shape {
+	case shapeCircle:
+		geomType = "Point"
+		if g.Center != nil {
+			coords = toLonLat(*g.Center)
+		}
+		props["radius_m"] = g.RadiusM
+	case shapeCorridor:
+		geomType = "LineString"
+		coords = ringToLonLat(g.Coordinates)
+		props["width_m"] = g.WidthM
+	case shapeMultiPolygon:
+		geomType = "MultiPolygon"
+		polygons := make([][][][2]float64, 0, len(g.Polygons))
+		for _, rings := range g.Polygons {
+			polygons = append(polygons, ringsToLonLat(rings))
+		}
+		coords = polygons
+	default:
+		geomType = "Polygon"
+		coords = ringsToLonLat(append([][][2]float64{g.Coordinates}, g.Holes...))
+	}
+
+	raw, _ := json.Marshal(coords)
+	return geoJSONFeature{
+		Type:       "Feature",
+		ID:         g.ID,
+		Geometry:   &geoJSONGeometry{Type: geomType, Coordinates: raw},
+		Properties: props,
+	}
+}
+
+func geofenceFromGeoJSON(f geoJSONFeature) (Geofence, error) {
+	var g Geofence
+	if f.Type != "Feature" {
+		return g, errors.New("entry is not a GeoJSON Feature")
+	}
+
+	g.Name, _ = f.Properties["name"].(string)
+	g.Description, _ = f.Properties["description"].(string)
+	g.Category, _ = f.Properties["category"].(string)
+	g.Metadata, _ = f.Properties["metadata"].(map[string]interface{})
+	if tags, ok := f.Properties["tags"].([]interface{}); ok {
+		for _, t := range tags {
+			if tag, ok := t.(string); ok {
+				g.Tags = append(g.Tags, tag)
+			}
+		}
+	}
+
+	if f.Geometry == nil {
+		return g, errors.New("feature has no geometry")
+	}
+
+	switch f.Geometry.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil {
+			return g, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		converted, err := ringsFromLonLat(rings)
+		if err != nil {
+			return g, err
+		}
+		if len(converted) == 0 {
+			return g, errors.New("Polygon has no rings")
+		}
+		g.Shape = shapePolygon
+		g.Coordinates = converted[0]
+		g.Holes = converted[1:]
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &polygons); err != nil {
+			return g, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+		g.Shape = shapeMultiPolygon
+		for _, rings := range polygons {
+			converted, err := ringsFromLonLat(rings)
+			if err != nil {
+				return g, err
+			}
+			g.Polygons = append(g.Polygons, converted)
+		}
+	case "Point":
+		var pos []float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &pos); err != nil {
+			return g, fmt.Errorf("invalid Point coordinates: %w", err)
+		}
+		center, err := fromLonLat(pos)
+		if err != nil {
+			return g, err
+		}
+		g.Shape = shapeCircle
+		g.Center = &center
+		g.RadiusM, _ = f.Properties["radius_m"].(float64)
+	case "LineString":
+		var line [][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &line); err != nil {
+			return g, fmt.Errorf("invalid LineString coordinates: %w", err)
+		}
+		path, err := ringFromLonLat(line)
+		if err != nil {
+			return g, err
+		}
+		g.Shape = shapeCorridor
+		g.Coordinates = path
+		g.WidthM, _ = f.Properties["width_m"].(float64)
+	default:
+		return g, fmt.Errorf("unsupported geometry type %q", f.Geometry.Type)
+	}
+
+	return g, nil
+}
+
+func toLonLat(p [2]float64) [2]float64 {
+	return [2]float64{p[1], p[0]}
+}
+
+func ringToLonLat(ring [][2]float64) [][2]float64 {
+	out := make([][2]float64, len(ring))
+	for i, p := range ring {
+		out[i] = toLonLat(p)
+	}
+	return out
+}
+
+func ringsToLonLat(rings [][][2]float64) [][][2]float64 {
+	out := make([][][2]float64, len(rings))
+	for i, ring := range rings {
+		out[i] = ringToLonLat(ring)
+	}
+	return out
+}
+
+func fromLonLat(pos []float64) ([2]float64, error) {
+	if len(pos) < 2 {
+		return [2]float64{}, errors.New("positions need at least longitude and latitude")
+	}
+	return [2]float64{pos[1], pos[0]}, nil
+}
+
+func ringFromLonLat(ring [][]float64) ([][2]float64, error) {
+	out := make([][2]float64, len(ring))
+	for i, pos := range ring {
+		p, err := fromLonLat(pos)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = p
+	}
+	return out, nil
+}
+
+func ringsFromLonLat(rings [][][]float64) ([][][2]float64, error) {
+	out := make([][][2]float64, len(rings))
+	for i, ring := range rings {
+		converted, err := ringFromLonLat(ring)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = converted
+	}
+	return out, nil
+}
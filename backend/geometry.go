@@ -0,0 +1,290 @@
+package main
+
+import "math"
+
+const earthRadiusM = 6371008.8
+
+type boundingBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+func boundsOfPoints(points [][2]float64) boundingBox {
+	if len(points) == 0 {
+		return boundingBox{}
+	}
+
+	b := boundingBox{MinLat: math.Inf(1), MinLon: math.Inf(1), MaxLat: math.Inf(-1), MaxLon: math.Inf(-1)}
+	for _, p := range points {
+		b.MinLat = math.Min(b.MinLat, p[0])
+		b.MaxLat = math.Max(b.MaxLat, p[0])
+		b.MinLon = math.Min(b.MinLon, p[1])
+		b.MaxLon = math.Max(b.MaxLon, p[1])
+	}
+	return b
+}
+
+func (b boundingBox) union(o boundingBox) boundingBox {
+	return boundingBox{
+		MinLat: math.Min(b.MinLat, o.MinLat),
+		MinLon: math.Min(b.MinLon, o.MinLon),
+		MaxLat: math.Max(b.MaxLat, o.MaxLat),
+		MaxLon: math.Max(b.MaxLon, o.MaxLon),
+	}
+}
+
+// expand grows the box by the given distance in meters on every side.
+func (b boundingBox) expand(meters float64) boundingBox {
+	dLat := meters / earthRadiusM * 180 / math.Pi
+	maxAbsLat := math.Max(math.Abs(b.MinLat), math.Abs(b.MaxLat))
+	cosLat := math.Max(math.Cos(toRadians(math.Min(maxAbsLat+dLat, 90))), 0.01)
+	dLon := dLat / cosLat
+
+	return boundingBox{
+		MinLat: math.Max(b.MinLat-dLat, -90),
+		MinLon: b.MinLon - dLon,
+		MaxLat: math.Min(b.MaxLat+dLat, 90),
+		MaxLon: b.MaxLon + dLon,
+	}
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Rings and paths are stored with longitudes in [-180, 180]. An edge whose
+// longitude jumps by more than 180° is taken to cross the antimeridian, so
+// such shapes are "unwrapped" into a continuous longitude range (which may
+// extend past ±180) before any planar math is done on them.
+
+func normalizeLonDelta(d float64) float64 {
+	for d > 180 {
+		d -= 360
+	}
+	for d < -180 {
+		d += 360
+	}
+	return d
+}
+
+func crossesAntimeridian(points [][2]float64) bool {
+	for i := 1; i < len(points); i++ {
+		if math.Abs(points[i][1]-points[i-1][1]) > 180 {
+			return true
+		}
+	}
+	return false
+}
+
+func unwrapPath(points [][2]float64) [][2]float64 {
+	if !crossesAntimeridian(points) {
+		return points
+	}
+
+	out := make([][2]float64, len(points))
+	out[0] = points[0]
+	for i := 1; i < len(points); i++ {
+		out[i] = [2]float64{points[i][0], out[i-1][1] + normalizeLonDelta(points[i][1]-points[i-1][1])}
+	}
+	return out
+}
+
+// shiftLonInto moves lon by ±360 when that places it inside the box.
+func shiftLonInto(lon float64, b boundingBox) float64 {
+	if lon < b.MinLon && lon+360 <= b.MaxLon {
+		return lon + 360
+	}
+	if lon > b.MaxLon && lon-360 >= b.MinLon {
+		return lon - 360
+	}
+	return lon
+}
+
+// planarRings unwraps a polygon (outer ring followed by holes) into one
+// continuous longitude frame. It reports whether any unwrapping was needed.
+func planarRings(rings [][][2]float64) ([][][2]float64, bool) {
+	crosses := false
+	for _, ring := range rings {
+		if crossesAntimeridian(ring) {
+			crosses = true
+			break
+		}
+	}
+	if !crosses {
+		return rings, false
+	}
+
+	out := make([][][2]float64, len(rings))
+	out[0] = unwrapPath(rings[0])
+	shell := boundsOfPoints(out[0])
+	for i, ring := range rings[1:] {
+		hole := unwrapPath(ring)
+		if len(hole) > 0 {
+			if shift := shiftLonInto(hole[0][1], shell) - hole[0][1]; shift != 0 {
+				shifted := make([][2]float64, len(hole))
+				for j, p := range hole {
+					shifted[j] = [2]float64{p[0], p[1] + shift}
+				}
+				hole = shifted
+			}
+		}
+		out[i+1] = hole
+	}
+	return out, true
+}
+
+// projectMeters maps a point onto a local equirectangular plane centered on
+// the origin. Accurate enough for the distances geofences deal with.
+func projectMeters(originLat, originLon, lat, lon float64) (x, y float64) {
+	x = toRadians(normalizeLonDelta(lon-originLon)) * math.Cos(toRadians(originLat)) * earthRadiusM
+	y = toRadians(lat-originLat) * earthRadiusM
+	return x, y
+}
+
+func pointToSegmentMeters(lat, lon float64, a, b [2]float64) float64 {
+	px, py := projectMeters(a[0], a[1], lat, lon)
+	bx, by := projectMeters(a[0], a[1], b[0], b[1])
+
+	t := 0.0
+	if lengthSq := bx*bx + by*by; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, (px*bx+py*by)/lengthSq))
+	}
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+func pointToPolylineMeters(lat, lon float64, path [][2]float64) float64 {
+	if len(path) == 1 {
+		return haversineMeters(lat, lon, path[0][0], path[0][1])
+	}
+
+	best := math.Inf(1)
+	for i := 1; i < len(path); i++ {
+		best = math.Min(best, pointToSegmentMeters(lat, lon, path[i-1], path[i]))
+	}
+	return best
+}
+
+// ringSignedArea returns the planar signed area of a closed ring in square
+// degrees, treating longitude as x and latitude as y. Positive means
+// counter-clockwise.
+func ringSignedArea(ring [][2]float64) float64 {
+	area := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i][1]*ring[i+1][0] - ring[i+1][1]*ring[i][0]
+	}
+	return area / 2
+}
+
+func orientation(a, b, c [2]float64) float64 {
+	return (b[1]-a[1])*(c[0]-a[0]) - (b[0]-a[0])*(c[1]-a[1])
+}
+
+func onSegment(a, b, p [2]float64) bool {
+	return math.Min(a[0], b[0]) <= p[0] && p[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= p[1] && p[1] <= math.Max(a[1], b[1])
+}
+
+func segmentsIntersect(p1, p2, p3, p4 [2]float64) bool {
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(p3, p4, p1)) ||
+		(d2 == 0 && onSegment(p3, p4, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, p3)) ||
+		(d4 == 0 && onSegment(p1, p2, p4))
+}
+
+// ringSelfIntersection reports the first pair of non-adjacent edges of a
+// closed ring that touch or cross.
+func ringSelfIntersection(ring [][2]float64) (int, int, bool) {
+	edges := len(ring) - 1
+	for i := 0; i < edges; i++ {
+		for j := i + 2; j < edges; j++ {
+			if i == 0 && j == edges-1 {
+				continue
+			}
+			if segmentsIntersect(ring[i], ring[i+1], ring[j], ring[j+1]) {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func ringsCross(a, b [][2]float64) bool {
+	for i := 0; i+1 < len(a); i++ {
+		for j := 0; j+1 < len(b); j++ {
+			if segmentsIntersect(a[i], a[i+1], b[j], b[j+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sphericalRingArea returns the area in square meters enclosed by a closed
+// ring on the sphere (Chamberlain & Duquette, "Some Algorithms for Polygons
+// on a Sphere", 2007).
+func sphericalRingArea(ring [][2]float64) float64 {
+	n := len(ring) - 1
+	if n < 3 {
+		return 0
+	}
+
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		prev, next := ring[(i+n-1)%n], ring[(i+1)%n]
+		sum += toRadians(normalizeLonDelta(next[1]-prev[1])) * math.Sin(toRadians(ring[i][0]))
+	}
+	return math.Abs(sum) * earthRadiusM * earthRadiusM / 2
+}
+
+func pathLengthMeters(path [][2]float64) float64 {
+	length := 0.0
+	for i := 1; i < len(path); i++ {
+		length += haversineMeters(path[i-1][0], path[i-1][1], path[i][0], path[i][1])
+	}
+	return length
+}
+
+// ringCentroid returns the planar centroid and unsigned area of a closed
+// ring, projected onto the local plane around origin.
+func ringCentroid(origin [2]float64, ring [][2]float64) (x, y, area float64) {
+	signed := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		x1, y1 := projectMeters(origin[0], origin[1], ring[i][0], ring[i][1])
+		x2, y2 := projectMeters(origin[0], origin[1], ring[i+1][0], ring[i+1][1])
+		cross := x1*y2 - x2*y1
+		signed += cross
+		x += (x1 + x2) * cross
+		y += (y1 + y2) * cross
+	}
+	if signed == 0 {
+		return 0, 0, 0
+	}
+	return x / (3 * signed), y / (3 * signed), math.Abs(signed) / 2
+}
+
+// unprojectMeters is the inverse of projectMeters, with the longitude
+// normalized to [-180, 180].
+func unprojectMeters(originLat, originLon, x, y float64) (lat, lon float64) {
+	lat = originLat + y/earthRadiusM*180/math.Pi
+	lon = originLon + x/(earthRadiusM*math.Cos(toRadians(originLat)))*180/math.Pi
+	return lat, normalizeLonDelta(lon)
+}
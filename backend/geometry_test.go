@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+// datelineSquare spans latitudes -10..10 and longitudes 179..-179, crossing
+// the antimeridian.
+var datelineSquare = [][2]float64{{-10, 179}, {-10, -179}, {10, -179}, {10, 179}, {-10, 179}}
+
+func TestPointInPolygonAcrossDateline(t *testing.T) {
+	hole := [][2]float64{{-1, 179.8}, {-1, -179.8}, {1, -179.8}, {1, 179.8}, {-1, 179.8}}
+
+	tests := []struct {
+		name     string
+		rings    [][][2]float64
+		lat, lon float64
+		want     bool
+	}{
+		{"at 180", [][][2]float64{datelineSquare}, 0, 180, true},
+		{"at -180", [][][2]float64{datelineSquare}, 0, -180, true},
+		{"at 179.5", [][][2]float64{datelineSquare}, 0, 179.5, true},
+		{"at -179.5", [][][2]float64{datelineSquare}, 0, -179.5, true},
+		{"west of the polygon", [][][2]float64{datelineSquare}, 0, 178.5, false},
+		{"east of the polygon", [][][2]float64{datelineSquare}, 0, -178.5, false},
+		{"opposite side of the globe", [][][2]float64{datelineSquare}, 0, 0, false},
+		{"north of the polygon", [][][2]float64{datelineSquare}, 11, 180, false},
+		{"inside hole at 180", [][][2]float64{datelineSquare, hole}, 0, 180, false},
+		{"inside hole at -180", [][][2]float64{datelineSquare, hole}, 0, -180, false},
+		{"outside hole at 179.5", [][][2]float64{datelineSquare, hole}, 0, 179.5, true},
+		{"outside hole at -179.5", [][][2]float64{datelineSquare, hole}, 0, -179.5, true},
+	}
+	for _, tt := range tests {
+		if got := isPointInPolygonWithHoles(tt.lat, tt.lon, tt.rings); got != tt.want {
+			t.Errorf("%s: isPointInPolygonWithHoles(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
+
+func TestPlanarRings(t *testing.T) {
+	tests := []struct {
+		name        string
+		rings       [][][2]float64
+		wantCrosses bool
+		wantBounds  boundingBox
+	}{
+		{"local polygon", [][][2]float64{square(0, 0, 1, 1)}, false, boundingBox{0, 0, 1, 1}},
+		{"dateline polygon", [][][2]float64{datelineSquare}, true, boundingBox{-10, 179, 10, 181}},
+		{"dateline polygon with hole west of 180", [][][2]float64{datelineSquare, square(-1, 179.2, 1, 179.8)}, true, boundingBox{-10, 179, 10, 181}},
+		{"dateline polygon with hole east of 180", [][][2]float64{datelineSquare, square(-1, -179.8, 1, -179.2)}, true, boundingBox{-10, 179, 10, 181}},
+	}
+	for _, tt := range tests {
+		planar, crosses := planarRings(tt.rings)
+		if crosses != tt.wantCrosses {
+			t.Errorf("%s: crosses = %v, want %v", tt.name, crosses, tt.wantCrosses)
+		}
+		if got := boundsOfPoints(planar[0]); got != tt.wantBounds {
+			t.Errorf("%s: shell bounds = %+v, want %+v", tt.name, got, tt.wantBounds)
+		}
+		for i, ring := range planar[1:] {
+			b := boundsOfPoints(ring)
+			if b.MinLon < tt.wantBounds.MinLon || b.MaxLon > tt.wantBounds.MaxLon {
+				t.Errorf("%s: hole %d bounds %+v not inside the shell frame", tt.name, i, b)
+			}
+		}
+	}
+}
+
+func TestCircleAndCorridorAcrossDateline(t *testing.T) {
+	circle := &Geofence{Shape: shapeCircle, Center: &[2]float64{0, 179.99}, RadiusM: 5000}
+	corridor := &Geofence{Shape: shapeCorridor, Coordinates: [][2]float64{{0, 179.9}, {0, -179.9}}, WidthM: 1000}
+
+	tests := []struct {
+		name     string
+		g        *Geofence
+		lat, lon float64
+		want     bool
+	}{
+		{"circle at 180", circle, 0, 180, true},
+		{"circle at -180", circle, 0, -180, true},
+		{"circle at -179.99", circle, 0, -179.99, true},
+		{"circle at -179.9", circle, 0, -179.9, false},
+		{"circle at 179.9", circle, 0, 179.9, false},
+		{"corridor at 180", corridor, 0.001, 180, true},
+		{"corridor at -180", corridor, 0.001, -180, true},
+		{"corridor at 179.95", corridor, 0, 179.95, true},
+		{"corridor at -179.95", corridor, 0, -179.95, true},
+		{"corridor beyond its width", corridor, 0.05, 180, false},
+		{"corridor on the opposite side of the globe", corridor, 0, 0, false},
+	}
+	for _, tt := range tests {
+		if got := tt.g.contains(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("%s: contains(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
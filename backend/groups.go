@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// GeofenceGroup organises geofences into a tree (e.g. city, district,
+// depot). A geofence belongs to at most one group, and a vehicle is inside
+// a group while it is inside any geofence of the group or its subgroups.
+type GeofenceGroup struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentID    string `json:"parent_id,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+const groupColumns = `id, name, description, parent_id, created_at`
+
+func scanGroup(row rowScanner) (GeofenceGroup, error) {
+	var g GeofenceGroup
+	var parentID sql.NullString
+	err := row.Scan(&g.ID, &g.Name, &g.Description, &parentID, &g.CreatedAt)
+	g.ParentID = parentID.String
+	return g, err
+}
+
+func loadGroup(id string) (GeofenceGroup, error) {
+	return scanGroup(db.QueryRow("SELECT "+groupColumns+" FROM geofence_groups WHERE id = $1", id))
+}
+
+func groupExists(id string) bool {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM geofence_groups WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		log.Println("Error querying geofence groups:", err)
+	}
+	return exists
+}
+
+func loadGroupParents() (map[string]string, error) {
+	rows, err := db.Query(`SELECT id, parent_id FROM geofence_groups WHERE parent_id IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := make(map[string]string)
+	for rows.Next() {
+		var id, parentID string
+		if err := rows.Scan(&id, &parentID); err != nil {
+			return nil, err
+		}
+		parents[id] = parentID
+	}
+	return parents, rows.Err()
+}
+
+// groupSubtreeQuery selects the ids of the group bound to parameter n and
+// all of its descendants.
+func groupSubtreeQuery(n int) string {
+	return fmt.Sprintf(`WITH RECURSIVE subtree AS (
+		SELECT id FROM geofence_groups WHERE id = $%d
+		UNION ALL
+		SELECT gg.id FROM geofence_groups gg JOIN subtree s ON gg.parent_id = s.id
+	) SELECT id FROM subtree`, n)
+}
+
+// validateGroup checks the group's fields and that its parent exists and
+// is not the group itself or one of its descendants.
+func validateGroup(g *GeofenceGroup) error {
+	var errs validationErrors
+
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		errs.add("name", "is required")
+	}
+
+	if g.ParentID != "" {
+		if !groupExists(g.ParentID) {
+			errs.add("parent_id", "unknown group %q", g.ParentID)
+		} else if g.ID != "" {
+			var cycle bool
+			err := db.QueryRow(
+				`SELECT $2::varchar IN (`+groupSubtreeQuery(1)+`)`,
+				g.ID, g.ParentID,
+			).Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				errs.add("parent_id", "a group cannot be nested inside itself or one of its subgroups")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func createGroup(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	var req GeofenceGroup
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.ID = ""
+
+	if err := validateGroup(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": err,
+		}, startTime)
+		return
+	}
+
+	req.ID = "grp_" + uuid.New().String()
+	_, err := db.Exec(
+		`INSERT INTO geofence_groups (id, name, description, parent_id) VALUES ($1, $2, $3, $4)`,
+		req.ID, req.Name, req.Description, nullString(req.ParentID),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	geofenceIndex.invalidate()
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":        req.ID,
+		"name":      req.Name,
+		"parent_id": req.ParentID,
+	}, startTime)
+}
+
+func getGroups(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	rows, err := db.Query("SELECT " + groupColumns + " FROM geofence_groups ORDER BY name")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	groups := []GeofenceGroup{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			log.Println("Error scanning group:", err)
+			continue
+		}
+		groups = append(groups, g)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"groups": groups,
+	}, startTime)
+}
+
+// getGroup returns a group with its direct subgroups and member geofences.
+func getGroup(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "groupID")
+
+	group, err := loadGroup(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query("SELECT "+groupColumns+" FROM geofence_groups WHERE parent_id = $1 ORDER BY name", id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	subgroups := []GeofenceGroup{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			log.Println("Error scanning group:", err)
+			continue
+		}
+		subgroups = append(subgroups, g)
+	}
+
+	rows, err = db.Query("SELECT "+geofenceColumns+" FROM geofences WHERE group_id = $1 AND status <> 'deleted' ORDER BY name", id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	geofences := []Geofence{}
+	for rows.Next() {
+		g, err := scanGeofence(rows)
+		if err != nil {
+			log.Println("Error scanning geofence:", err)
+			continue
+		}
+		geofences = append(geofences, g)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"group":     group,
+		"subgroups": subgroups,
+		"geofences": geofences,
+	}, startTime)
+}
+
+func updateGroup(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "groupID")
+
+	existing, err := loadGroup(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// PUT replaces the group; PATCH applies the given fields on top of it.
+	g := GeofenceGroup{}
+	if r.Method == http.MethodPatch {
+		g = existing
+	}
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.ID, g.CreatedAt = existing.ID, existing.CreatedAt
+
+	if err := validateGroup(&g); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": err,
+		}, startTime)
+		return
+	}
+
+	_, err = db.Exec(
+		`UPDATE geofence_groups SET name = $2, description = $3, parent_id = $4 WHERE id = $1`,
+		g.ID, g.Name, g.Description, nullString(g.ParentID),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	geofenceIndex.invalidate()
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"group": g,
+	}, startTime)
+}
+
+// deleteGroup removes an empty group. Groups that still have subgroups,
+// geofences or alert configurations are rejected.
+func deleteGroup(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "groupID")
+
+	if _, err := loadGroup(id); err == sql.ErrNoRows {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var subgroups, geofences, alerts int
+	err := db.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM geofence_groups WHERE parent_id = $1),
+			(SELECT COUNT(*) FROM geofences WHERE group_id = $1 AND status <> 'deleted'),
+			(SELECT COUNT(*) FROM alert_configs WHERE group_id = $1)`,
+		id,
+	).Scan(&subgroups, &geofences, &alerts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if subgroups > 0 || geofences > 0 || alerts > 0 {
+		http.Error(w, fmt.Sprintf("group still has %d subgroups, %d geofences and %d alert configurations", subgroups, geofences, alerts), http.StatusConflict)
+		return
+	}
+
+	// Deleted geofences keep their rows for history but drop the reference.
+	if _, err := db.Exec(`UPDATE geofences SET group_id = NULL WHERE group_id = $1`, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM geofence_groups WHERE id = $1`, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	geofenceIndex.invalidate()
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": "deleted",
+	}, startTime)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// groupsInside maps every group containing one of the given geofences,
+// directly or through a subgroup, to one such geofence.
+func groupsInside(geofenceIDs map[string]bool) map[string]string {
+	groups := make(map[string]string)
+	for geofenceID := range geofenceIDs {
+		g, ok := geofenceIndex.get(geofenceID)
+		if !ok || g.GroupID == "" {
+			continue
+		}
+		for _, groupID := range geofenceIndex.groupPath(g.GroupID) {
+			if _, seen := groups[groupID]; !seen {
+				groups[groupID] = geofenceID
+			}
+		}
+	}
+	return groups
+}
+
+// checkGroupTransitions fires a single group entry when a vehicle enters
+// the first geofence of a group and a single exit when it leaves the last
+// one; moving between member geofences fires nothing at group level.
+// passThrough flags transitions inferred between two reports.
+func checkGroupTransitions(vehicleID string, prevInside, nowInside map[string]bool, lat, lon float64, timestamp string, passThrough bool) {
+	before := groupsInside(prevInside)
+	after := groupsInside(nowInside)
+
+	for groupID, geofenceID := range after {
+		if _, ok := before[groupID]; !ok {
+			triggerGroupEvent(vehicleID, groupID, geofenceID, "entry", lat, lon, timestamp, passThrough)
+		}
+	}
+	for groupID, geofenceID := range before {
+		if _, ok := after[groupID]; !ok {
+			triggerGroupEvent(vehicleID, groupID, geofenceID, "exit", lat, lon, timestamp, passThrough)
+		}
+	}
+}
+
+// emitGroupExits fires a group exit for each vehicle that was inside a
+// group only through a geofence that has just been deactivated or deleted,
+// at the vehicle's last evaluated location.
+func emitGroupExits(geofenceID, groupID string, cleared []clearedState) {
+	if groupID == "" || len(cleared) == 0 {
+		return
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	path := geofenceIndex.groupPath(groupID)
+	for _, c := range cleared {
+		remaining, err := insideGeofenceIDs(c.vehicleID)
+		if err != nil {
+			log.Println("Error loading geofence state:", err)
+			continue
+		}
+		still := groupsInside(remaining)
+		for _, id := range path {
+			if _, ok := still[id]; !ok {
+				triggerGroupEvent(c.vehicleID, id, geofenceID, "exit", c.lat, c.lon, timestamp, false)
+			}
+		}
+	}
+}
+
+func triggerGroupEvent(vehicleID, groupID, geofenceID, eventType string, lat, lon float64, timestamp string, passThrough bool) {
+	var exists bool
+	err := db.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM alert_configs
+			WHERE group_id = $1 AND status = 'active'
+			AND (vehicle_id = $2 OR vehicle_id IS NULL)
+			AND (event_type = $3 OR event_type = 'both')
+		)`,
+		groupID, vehicleID, eventType,
+	).Scan(&exists)
+	if err != nil {
+		log.Println("Error querying alert configs:", err)
+		return
+	}
+	if !exists {
+		return
+	}
+
+	var groupName string
+	db.QueryRow(`SELECT name FROM geofence_groups WHERE id = $1`, groupID).Scan(&groupName)
+
+	recordViolation(vehicleID, geofenceID, groupID, eventType, lat, lon, timestamp, passThrough)
+
+	alert := newAlert(vehicleID, geofenceID, eventType, lat, lon, timestamp)
+	alert["group"] = map[string]string{
+		"group_id":   groupID,
+		"group_name": groupName,
+	}
+	if passThrough {
+		alert["pass_through"] = true
+	}
+	recordAlertHistory(vehicleID, geofenceID, groupID, eventType, lat, lon, timestamp, passThrough)
+
+	hub.broadcast <- alert
+}
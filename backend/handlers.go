@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,23 +15,41 @@ import (
 )
 
 type Geofence struct {
-	ID          string        `json:"id"`
-	Name        string        `json:"name"`
-	Description string        `json:"description"`
-	Coordinates [][2]float64  `json:"coordinates"`
-	Category    string        `json:"category"`
-	Status      string        `json:"status"`
-	CreatedAt   string        `json:"created_at"`
+	ID                  string                 `json:"id"`
+	Name                string                 `json:"name"`
+	Description         string                 `json:"description"`
+	Shape               string                 `json:"shape"`
+	Coordinates         [][2]float64           `json:"coordinates"`
+	Holes               [][][2]float64         `json:"holes,omitempty"`
+	Polygons            [][][][2]float64       `json:"polygons,omitempty"`
+	Center              *[2]float64            `json:"center,omitempty"`
+	RadiusM             float64                `json:"radius_m,omitempty"`
+	WidthM              float64                `json:"width_m,omitempty"`
+	CrossesAntimeridian bool                   `json:"crosses_antimeridian,omitempty"`
+	HysteresisM         float64                `json:"hysteresis_m"`
+	Schedule            *GeofenceSchedule      `json:"schedule,omitempty"`
+	GroupID             string                 `json:"group_id,omitempty"`
+	AreaM2              float64                `json:"area_m2"`
+	PerimeterM          float64                `json:"perimeter_m"`
+	Centroid            *[2]float64            `json:"centroid,omitempty"`
+	BBox                *boundingBox           `json:"bbox,omitempty"`
+	Tags                []string               `json:"tags,omitempty"`
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`
+	Category            string                 `json:"category"`
+	Status              string                 `json:"status"`
+	CreatedAt           string                 `json:"created_at"`
 }
 
 type Vehicle struct {
-	ID            string `json:"id"`
-	VehicleNumber string `json:"vehicle_number"`
-	DriverName    string `json:"driver_name"`
-	VehicleType   string `json:"vehicle_type"`
-	Phone         string `json:"phone"`
-	Status        string `json:"status"`
-	CreatedAt     string `json:"created_at"`
+	ID            string                 `json:"id"`
+	VehicleNumber string                 `json:"vehicle_number"`
+	DriverName    string                 `json:"driver_name"`
+	VehicleType   string                 `json:"vehicle_type"`
+	Phone         string                 `json:"phone"`
+	Tags          []string               `json:"tags,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	Status        string                 `json:"status"`
+	CreatedAt     string                 `json:"created_at"`
 }
 
 type Location struct {
@@ -40,10 +59,11 @@ type Location struct {
 }
 
 type CurrentGeofence struct {
-	GeofenceID   string `json:"geofence_id"`
-	GeofenceName string `json:"geofence_name"`
-	Category     string `json:"category,omitempty"`
-	Status       string `json:"status,omitempty"`
+	GeofenceID          string  `json:"geofence_id"`
+	GeofenceName        string  `json:"geofence_name"`
+	Category            string  `json:"category,omitempty"`
+	Status              string  `json:"status,omitempty"`
+	DistanceToBoundaryM float64 `json:"distance_to_boundary_m"`
 }
 
 type AlertConfig struct {
@@ -56,73 +76,83 @@ type AlertConfig struct {
 }
 
 type Violation struct {
-	ID            string  `json:"id"`
-	VehicleID     string  `json:"vehicle_id"`
-	VehicleNumber string  `json:"vehicle_number"`
-	GeofenceID    string  `json:"geofence_id"`
-	GeofenceName  string  `json:"geofence_name"`
-	EventType     string  `json:"event_type"`
-	Latitude      float64 `json:"latitude"`
-	Longitude     float64 `json:"longitude"`
-	Timestamp     string  `json:"timestamp"`
+	ID              string  `json:"id"`
+	VehicleID       string  `json:"vehicle_id"`
+	VehicleNumber   string  `json:"vehicle_number"`
+	GeofenceID      string  `json:"geofence_id"`
+	GeofenceName    string  `json:"geofence_name"`
+	EventType       string  `json:"event_type"`
+	Latitude        float64 `json:"latitude"`
+	Longitude       float64 `json:"longitude"`
+	Timestamp       string  `json:"timestamp"`
+	GeofenceVersion *int    `json:"geofence_version,omitempty"`
+	GroupID         string  `json:"group_id,omitempty"`
+	PassThrough     bool    `json:"pass_through"`
 }
 
 func createGeofence(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
-	var req struct {
-		Name        string      `json:"name"`
-		Description string      `json:"description"`
-		Coordinates [][2]float64 `json:"coordinates"`
-		Category    string      `json:"category"`
-	}
+	var req Geofence
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len(req.Coordinates) < 4 {
-		http.Error(w, "Minimum 4 points required (3 unique + 1 closing point)", http.StatusBadRequest)
+	if err := validateGeofence(&req, r.URL.Query().Get("repair") == "true"); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": err,
+		}, startTime)
 		return
 	}
 
-	if req.Coordinates[0] != req.Coordinates[len(req.Coordinates)-1] {
-		http.Error(w, "First and last coordinates must be identical", http.StatusBadRequest)
+	overlaps, err := checkOverlaps(&req)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":    "validation failed",
+			"errors":   err,
+			"overlaps": overlaps,
+		}, startTime)
 		return
 	}
 
-	coordJSON, _ := json.Marshal(req.Coordinates)
-	id := "geo_" + uuid.New().String()
-
-	_, err := db.Exec(
-		`INSERT INTO geofences (id, name, description, coordinates, category, status)
-		VALUES ($1, $2, $3, $4, $5, 'active')`,
-		id, req.Name, req.Description, string(coordJSON), req.Category,
-	)
-
-	if err != nil {
+	req.ID = "geo_" + uuid.New().String()
+	if err := insertGeofence(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	respondJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":     id,
-		"name":   req.Name,
-		"status": "active",
+		"id":       req.ID,
+		"name":     req.Name,
+		"shape":    req.Shape,
+		"status":   "active",
+		"overlaps": overlaps,
 	}, startTime)
 }
 
 func getGeofences(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	category := r.URL.Query().Get("category")
+	groupID := r.URL.Query().Get("group_id")
 
-	query := "SELECT id, name, description, coordinates, category, status, created_at FROM geofences"
+	query := "SELECT " + geofenceColumns + " FROM geofences WHERE status <> 'deleted'"
 	var args []interface{}
+	argCount := 1
 
 	if category != "" {
-		query += " WHERE category = $1"
+		query += fmt.Sprintf(" AND category = $%d", argCount)
 		args = append(args, category)
+		argCount++
+	}
+	if groupID != "" {
+		query += fmt.Sprintf(" AND group_id IN (%s)", groupSubtreeQuery(argCount))
+		args = append(args, groupID)
+		argCount++
 	}
+	filter, args := tagMetadataFilter(r.URL.Query(), args)
+	query += filter
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -133,28 +163,142 @@ func getGeofences(w http.ResponseWriter, r *http.Request) {
 
 	var geofences []Geofence
 	for rows.Next() {
-		var g Geofence
-		var coordStr string
-		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &coordStr, &g.Category, &g.Status, &g.CreatedAt); err != nil {
-			log.Fatal(err)
+		g, err := scanGeofence(rows)
+		if err != nil {
+			log.Println("Error scanning geofence:", err)
+			continue
 		}
-
-		json.Unmarshal([]byte(coordStr), &g.Coordinates)
 		geofences = append(geofences, g)
 	}
 
+	switch r.URL.Query().Get("format") {
+	case "geojson":
+		respondJSON(w, http.StatusOK, geofencesToGeoJSON(geofences), startTime)
+		return
+	case "kml":
+		respondKML(w, geofences, startTime)
+		return
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"geofences": geofences,
 	}, startTime)
 }
 
+func getGeofence(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "geofenceID")
+
+	g, err := loadGeofence(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"geofence": g,
+	}, startTime)
+}
+
+func updateGeofence(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "geofenceID")
+
+	existing, err := loadGeofence(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// PUT replaces the geofence; PATCH applies the given fields on top of it.
+	g := Geofence{Status: existing.Status}
+	if r.Method == http.MethodPatch {
+		g = existing
+	}
+	if err := decodeUpdate(r.Body, &g, &g.Metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.ID, g.CreatedAt = existing.ID, existing.CreatedAt
+
+	if g.Status != "active" && g.Status != "inactive" {
+		http.Error(w, "status must be active or inactive", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateGeofence(&g, r.URL.Query().Get("repair") == "true"); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": err,
+		}, startTime)
+		return
+	}
+
+	overlaps := []GeofenceOverlap{}
+	if g.Status == "active" {
+		var err error
+		if overlaps, err = checkOverlaps(&g); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error":    "validation failed",
+				"errors":   err,
+				"overlaps": overlaps,
+			}, startTime)
+			return
+		}
+	}
+
+	if err := saveGeofence(&g); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	g.CrossesAntimeridian = g.crossesAntimeridian()
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"geofence": g,
+		"overlaps": overlaps,
+	}, startTime)
+}
+
+func deleteGeofence(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	id := chi.URLParam(r, "geofenceID")
+
+	if _, err := loadGeofence(id); err == sql.ErrNoRows {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := softDeleteGeofence(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": "deleted",
+	}, startTime)
+}
+
 func registerVehicle(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	var req struct {
-		VehicleNumber string `json:"vehicle_number"`
-		DriverName    string `json:"driver_name"`
-		VehicleType   string `json:"vehicle_type"`
-		Phone         string `json:"phone"`
+		VehicleNumber string                 `json:"vehicle_number"`
+		DriverName    string                 `json:"driver_name"`
+		VehicleType   string                 `json:"vehicle_type"`
+		Phone         string                 `json:"phone"`
+		Tags          []string               `json:"tags"`
+		Metadata      map[string]interface{} `json:"metadata"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -162,11 +306,23 @@ func registerVehicle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var errs validationErrors
+	req.Tags = validateTags(&errs, req.Tags)
+	validateMetadata(&errs, req.Metadata)
+	if len(errs) > 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": errs,
+		}, startTime)
+		return
+	}
+	tags, metadata := tagsAndMetadataValues(req.Tags, req.Metadata)
+
 	id := "veh_" + uuid.New().String()
 	_, err := db.Exec(
-		`INSERT INTO vehicles (id, vehicle_number, driver_name, vehicle_type, phone, status)
-		VALUES ($1, $2, $3, $4, $5, 'active')`,
-		id, req.VehicleNumber, req.DriverName, req.VehicleType, req.Phone,
+		`INSERT INTO vehicles (id, vehicle_number, driver_name, vehicle_type, phone, tags, metadata, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'active')`,
+		id, req.VehicleNumber, req.DriverName, req.VehicleType, req.Phone, tags, metadata,
 	)
 
 	if err != nil {
@@ -184,9 +340,11 @@ func registerVehicle(w http.ResponseWriter, r *http.Request) {
 func getVehicles(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
+	filter, args := tagMetadataFilter(r.URL.Query(), nil)
 	rows, err := db.Query(
-		`SELECT id, vehicle_number, driver_name, vehicle_type, phone, status, created_at
-		FROM vehicles ORDER BY created_at DESC`,
+		`SELECT `+vehicleColumns+`
+		FROM vehicles WHERE 1=1`+filter+` ORDER BY created_at DESC`,
+		args...,
 	)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -196,9 +354,10 @@ func getVehicles(w http.ResponseWriter, r *http.Request) {
 
 	var vehicles []Vehicle
 	for rows.Next() {
-		var v Vehicle
-		if err := rows.Scan(&v.ID, &v.VehicleNumber, &v.DriverName, &v.VehicleType, &v.Phone, &v.Status, &v.CreatedAt); err != nil {
-			log.Fatal(err)
+		v, err := scanVehicle(rows)
+		if err != nil {
+			log.Println("Error scanning vehicle:", err)
+			continue
 		}
 		vehicles = append(vehicles, v)
 	}
@@ -208,39 +367,92 @@ func getVehicles(w http.ResponseWriter, r *http.Request) {
 	}, startTime)
 }
 
-func updateVehicleLocation(w http.ResponseWriter, r *http.Request) {
+const vehicleColumns = `id, vehicle_number, driver_name, vehicle_type, phone, tags, metadata, status, created_at`
+
+func scanVehicle(row rowScanner) (Vehicle, error) {
+	var v Vehicle
+	var tagsJSON, metaJSON []byte
+	err := row.Scan(&v.ID, &v.VehicleNumber, &v.DriverName, &v.VehicleType, &v.Phone, &tagsJSON, &metaJSON, &v.Status, &v.CreatedAt)
+	v.Tags, v.Metadata = parseTagsAndMetadata(tagsJSON, metaJSON)
+	return v, err
+}
+
+// updateVehicle applies the given fields, including tags and metadata, to
+// a registered vehicle.
+func updateVehicle(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
-	var req struct {
-		VehicleID string  `json:"vehicle_id"`
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
-		Timestamp string  `json:"timestamp"`
+	id := chi.URLParam(r, "vehicleID")
+
+	v, err := scanVehicle(db.QueryRow("SELECT "+vehicleColumns+" FROM vehicles WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	createdAt := v.CreatedAt
+	if err := decodeUpdate(r.Body, &v, &v.Metadata); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	v.ID, v.CreatedAt = id, createdAt
 
-	locID := "loc_" + uuid.New().String()
-	_, err := db.Exec(
-		`INSERT INTO locations (id, vehicle_id, latitude, longitude, timestamp)
-		VALUES ($1, $2, $3, $4, $5)`,
-		locID, req.VehicleID, req.Latitude, req.Longitude, req.Timestamp,
-	)
+	var errs validationErrors
+	if v.Status != "active" && v.Status != "inactive" {
+		errs.add("status", "must be active or inactive")
+	}
+	v.Tags = validateTags(&errs, v.Tags)
+	validateMetadata(&errs, v.Metadata)
+	if len(errs) > 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": errs,
+		}, startTime)
+		return
+	}
 
+	tags, metadata := tagsAndMetadataValues(v.Tags, v.Metadata)
+	_, err = db.Exec(
+		`UPDATE vehicles SET vehicle_number = $2, driver_name = $3, vehicle_type = $4, phone = $5,
+		tags = $6, metadata = $7, status = $8
+		WHERE id = $1`,
+		v.ID, v.VehicleNumber, v.DriverName, v.VehicleType, v.Phone, tags, metadata, v.Status,
+	)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	currentGeofences := checkGeofences(req.VehicleID, req.Latitude, req.Longitude)
-	checkAndTriggerAlerts(req.VehicleID, req.Latitude, req.Longitude, req.Timestamp)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"vehicle": v,
+	}, startTime)
+}
+
+func updateVehicleLocation(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	var req locationReport
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := insertLocations([]locationReport{req}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	currentGeofences, outOfOrder := processLocation(req)
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"vehicle_id":       req.VehicleID,
 		"location_updated": true,
+		"out_of_order":     outOfOrder,
 		"current_geofences": currentGeofences,
+		"nearby_geofences": nearbyOutside(req.Latitude, req.Longitude, parseTimestamp(req.Timestamp)),
 	}, startTime)
 }
 
@@ -273,26 +485,30 @@ func getVehicleLocation(w http.ResponseWriter, r *http.Request) {
 			"vehicle_number":   veh.VehicleNumber,
 			"current_location": nil,
 			"current_geofences": []interface{}{},
+			"nearby_geofences": []interface{}{},
 		}, startTime)
 		return
 	}
 
-	currentGeofences := checkGeofences(vehicleID, loc.Latitude, loc.Longitude)
+	currentGeofences := checkGeofences(vehicleID, loc.Latitude, loc.Longitude, parseTimestamp(loc.Timestamp))
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"vehicle_id":       vehicleID,
 		"vehicle_number":   veh.VehicleNumber,
 		"current_location": loc,
 		"current_geofences": currentGeofences,
+		"nearby_geofences": nearbyOutside(loc.Latitude, loc.Longitude, parseTimestamp(loc.Timestamp)),
 	}, startTime)
 }
 
 func configureAlert(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	var req struct {
-		GeofenceID string `json:"geofence_id"`
-		VehicleID  string `json:"vehicle_id"`
-		EventType  string `json:"event_type"`
+		GeofenceID   string `json:"geofence_id"`
+		GroupID      string `json:"group_id"`
+		VehicleID    string `json:"vehicle_id"`
+		EventType    string `json:"event_type"`
+		DwellSeconds int    `json:"dwell_seconds"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -300,11 +516,48 @@ func configureAlert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if (req.GeofenceID == "") == (req.GroupID == "") {
+		http.Error(w, "exactly one of geofence_id or group_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.GroupID != "" && req.EventType == "dwell" {
+		http.Error(w, "dwell alerts can only be configured for a geofence", http.StatusBadRequest)
+		return
+	}
+
+	switch req.EventType {
+	case "entry", "exit", "both":
+		req.DwellSeconds = 0
+	case "dwell":
+		if req.DwellSeconds <= 0 {
+			http.Error(w, "dwell alerts require a positive dwell_seconds threshold", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "event_type must be one of entry, exit, both, dwell", http.StatusBadRequest)
+		return
+	}
+
+	var geofenceID, groupID, vehicleID sql.NullString
+	if req.GeofenceID != "" {
+		geofenceID = sql.NullString{String: req.GeofenceID, Valid: true}
+	}
+	if req.GroupID != "" {
+		groupID = sql.NullString{String: req.GroupID, Valid: true}
+	}
+	if req.VehicleID != "" {
+		vehicleID = sql.NullString{String: req.VehicleID, Valid: true}
+	}
+	var dwellSeconds sql.NullInt64
+	if req.DwellSeconds > 0 {
+		dwellSeconds = sql.NullInt64{Int64: int64(req.DwellSeconds), Valid: true}
+	}
+
 	alertID := "alert_" + uuid.New().String()
 	_, err := db.Exec(
-		`INSERT INTO alert_configs (id, geofence_id, vehicle_id, event_type, status)
-		VALUES ($1, $2, $3, $4, 'active')`,
-		alertID, req.GeofenceID, req.VehicleID, req.EventType,
+		`INSERT INTO alert_configs (id, geofence_id, group_id, vehicle_id, event_type, dwell_seconds, status)
+		VALUES ($1, $2, $3, $4, $5, $6, 'active')`,
+		alertID, geofenceID, groupID, vehicleID, req.EventType, dwellSeconds,
 	)
 
 	if err != nil {
@@ -312,23 +565,34 @@ func configureAlert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, map[string]interface{}{
+	response := map[string]interface{}{
 		"alert_id":   alertID,
-		"geofence_id": req.GeofenceID,
 		"vehicle_id": req.VehicleID,
 		"event_type": req.EventType,
 		"status":     "active",
-	}, startTime)
+	}
+	if req.GroupID != "" {
+		response["group_id"] = req.GroupID
+	} else {
+		response["geofence_id"] = req.GeofenceID
+	}
+	if req.DwellSeconds > 0 {
+		response["dwell_seconds"] = req.DwellSeconds
+	}
+
+	respondJSON(w, http.StatusCreated, response, startTime)
 }
 
 func getAlerts(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	geofenceID := r.URL.Query().Get("geofence_id")
+	groupID := r.URL.Query().Get("group_id")
 	vehicleID := r.URL.Query().Get("vehicle_id")
 
-	query := `SELECT ac.id, ac.geofence_id, g.name, ac.vehicle_id, v.vehicle_number, ac.event_type, ac.status, ac.created_at
+	query := `SELECT ac.id, ac.geofence_id, g.name, ac.group_id, gg.name, ac.vehicle_id, v.vehicle_number, ac.event_type, ac.dwell_seconds, ac.status, ac.created_at
 	FROM alert_configs ac
-	JOIN geofences g ON ac.geofence_id = g.id
+	LEFT JOIN geofences g ON ac.geofence_id = g.id
+	LEFT JOIN geofence_groups gg ON ac.group_id = gg.id
 	LEFT JOIN vehicles v ON ac.vehicle_id = v.id WHERE 1=1`
 	var args []interface{}
 	argCount := 1
@@ -338,6 +602,11 @@ func getAlerts(w http.ResponseWriter, r *http.Request) {
 		args = append(args, geofenceID)
 		argCount++
 	}
+	if groupID != "" {
+		query += fmt.Sprintf(" AND ac.group_id = $%d", argCount)
+		args = append(args, groupID)
+		argCount++
+	}
 	if vehicleID != "" {
 		query += fmt.Sprintf(" AND ac.vehicle_id = $%d", argCount)
 		args = append(args, vehicleID)
@@ -353,22 +622,34 @@ func getAlerts(w http.ResponseWriter, r *http.Request) {
 
 	var alerts []map[string]interface{}
 	for rows.Next() {
-		var alertID, geofID, geoName, vehID, vehNum, eventType, status, createdAt string
-		if err := rows.Scan(&alertID, &geofID, &geoName, &vehID, &vehNum, &eventType, &status, &createdAt); err != nil {
-			log.Fatal(err)
+		var alertID, eventType, status, createdAt string
+		var geofID, geoName, grpID, grpName, vehID, vehNum sql.NullString
+		var dwellSeconds sql.NullInt64
+		if err := rows.Scan(&alertID, &geofID, &geoName, &grpID, &grpName, &vehID, &vehNum, &eventType, &dwellSeconds, &status, &createdAt); err != nil {
+			log.Println("Error scanning alert:", err)
+			continue
 		}
 
 		alert := map[string]interface{}{
-			"alert_id":      alertID,
-			"geofence_id":   geofID,
-			"geofence_name": geoName,
-			"event_type":    eventType,
-			"status":        status,
-			"created_at":    createdAt,
+			"alert_id":   alertID,
+			"event_type": eventType,
+			"status":     status,
+			"created_at": createdAt,
+		}
+		if geofID.Valid {
+			alert["geofence_id"] = geofID.String
+			alert["geofence_name"] = geoName.String
+		}
+		if grpID.Valid {
+			alert["group_id"] = grpID.String
+			alert["group_name"] = grpName.String
 		}
-		if vehID != "" {
-			alert["vehicle_id"] = vehID
-			alert["vehicle_number"] = vehNum
+		if vehID.Valid {
+			alert["vehicle_id"] = vehID.String
+			alert["vehicle_number"] = vehNum.String
+		}
+		if dwellSeconds.Valid {
+			alert["dwell_seconds"] = dwellSeconds.Int64
 		}
 		alerts = append(alerts, alert)
 	}
@@ -382,6 +663,7 @@ func getViolationsHistory(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	vehicleID := r.URL.Query().Get("vehicle_id")
 	geofenceID := r.URL.Query().Get("geofence_id")
+	groupID := r.URL.Query().Get("group_id")
 	startDate := r.URL.Query().Get("start_date")
 	endDate := r.URL.Query().Get("end_date")
 	limitStr := r.URL.Query().Get("limit")
@@ -393,7 +675,7 @@ func getViolationsHistory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	query := `SELECT v.id, v.vehicle_id, veh.vehicle_number, v.geofence_id, g.name, v.event_type, v.latitude, v.longitude, v.timestamp
+	query := `SELECT v.id, v.vehicle_id, veh.vehicle_number, v.geofence_id, g.name, v.event_type, v.latitude, v.longitude, v.timestamp, v.geofence_version, v.group_id, v.pass_through
 	FROM violations v
 	JOIN vehicles veh ON v.vehicle_id = veh.id
 	JOIN geofences g ON v.geofence_id = g.id WHERE 1=1`
@@ -410,6 +692,11 @@ func getViolationsHistory(w http.ResponseWriter, r *http.Request) {
 		args = append(args, geofenceID)
 		argCount++
 	}
+	if groupID != "" {
+		query += fmt.Sprintf(" AND v.group_id = $%d", argCount)
+		args = append(args, groupID)
+		argCount++
+	}
 	if startDate != "" {
 		query += fmt.Sprintf(" AND v.timestamp >= $%d", argCount)
 		args = append(args, startDate)
@@ -421,7 +708,7 @@ func getViolationsHistory(w http.ResponseWriter, r *http.Request) {
 		argCount++
 	}
 
-	countQuery := strings.Replace(query, "SELECT v.id, v.vehicle_id, veh.vehicle_number, v.geofence_id, g.name, v.event_type, v.latitude, v.longitude, v.timestamp", "SELECT COUNT(*)", 1)
+	countQuery := strings.Replace(query, "SELECT v.id, v.vehicle_id, veh.vehicle_number, v.geofence_id, g.name, v.event_type, v.latitude, v.longitude, v.timestamp, v.geofence_version, v.group_id, v.pass_through", "SELECT COUNT(*)", 1)
 	var totalCount int
 	db.QueryRow(countQuery, args...).Scan(&totalCount)
 
@@ -438,8 +725,16 @@ func getViolationsHistory(w http.ResponseWriter, r *http.Request) {
 	var violations []Violation
 	for rows.Next() {
 		var v Violation
-		if err := rows.Scan(&v.ID, &v.VehicleID, &v.VehicleNumber, &v.GeofenceID, &v.GeofenceName, &v.EventType, &v.Latitude, &v.Longitude, &v.Timestamp); err != nil {
-			log.Fatal(err)
+		var version sql.NullInt64
+		var groupID sql.NullString
+		if err := rows.Scan(&v.ID, &v.VehicleID, &v.VehicleNumber, &v.GeofenceID, &v.GeofenceName, &v.EventType, &v.Latitude, &v.Longitude, &v.Timestamp, &version, &groupID, &v.PassThrough); err != nil {
+			log.Println("Error scanning violation:", err)
+			continue
+		}
+		v.GroupID = groupID.String
+		if version.Valid {
+			n := int(version.Int64)
+			v.GeofenceVersion = &n
 		}
 		violations = append(violations, v)
 	}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KML coordinates are "lon,lat[,alt]" tuples separated by whitespace.
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Xmlns      string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	ID            string            `xml:"id,attr,omitempty"`
+	Name          string            `xml:"name"`
+	Description   string            `xml:"description,omitempty"`
+	ExtendedData  []kmlData         `xml:"ExtendedData>Data,omitempty"`
+	Polygon       *kmlPolygon       `xml:"Polygon,omitempty"`
+	MultiGeometry *kmlMultiGeometry `xml:"MultiGeometry,omitempty"`
+	Point         *kmlCoordinates   `xml:"Point,omitempty"`
+	LineString    *kmlCoordinates   `xml:"LineString,omitempty"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPolygon struct {
+	Outer kmlBoundary   `xml:"outerBoundaryIs"`
+	Inner []kmlBoundary `xml:"innerBoundaryIs,omitempty"`
+}
+
+type kmlBoundary struct {
+	Coordinates string `xml:"LinearRing>coordinates"`
+}
+
+type kmlMultiGeometry struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+type kmlCoordinates struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+func importGeofencesKML(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	defaultCategory := r.URL.Query().Get("category")
+	repair := r.URL.Query().Get("repair") == "true"
+
+	placemarks, err := decodeKMLPlacemarks(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(placemarks))
+	imported := 0
+	for i, pm := range placemarks {
+		result := map[string]interface{}{"index": i, "name": pm.Name}
+
+		g, err := geofenceFromKML(pm)
+		if g.Category == "" {
+			g.Category = defaultCategory
+		}
+		if err == nil {
+			err = validateGeofence(&g, repair)
+		}
+		var overlaps []GeofenceOverlap
+		if err == nil {
+			overlaps, err = checkOverlaps(&g)
+		}
+		if err == nil {
+			g.ID = "geo_" + uuid.New().String()
+			err = insertGeofence(&g)
+		}
+
+		if errs, ok := err.(validationErrors); ok {
+			result["status"] = "failed"
+			result["errors"] = errs
+		} else if err != nil {
+			result["status"] = "failed"
+			result["error"] = err.Error()
+		} else {
+			result["status"] = "created"
+			result["id"] = g.ID
+			imported++
+		}
+		if len(overlaps) > 0 {
+			result["overlaps"] = overlaps
+		}
+		results = append(results, result)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"imported": imported,
+		"failed":   len(placemarks) - imported,
+		"results":  results,
+	}, startTime)
+}
+
+// decodeKMLPlacemarks collects every Placemark in the document, including
+// those nested inside Folders.
+func decodeKMLPlacemarks(r io.Reader) ([]kmlPlacemark, error) {
+	decoder := xml.NewDecoder(r)
+	var placemarks []kmlPlacemark
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid KML: %w", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+
+		var pm kmlPlacemark
+		if err := decoder.DecodeElement(&pm, &start); err != nil {
+			return nil, fmt.Errorf("invalid KML placemark: %w", err)
+		}
+		placemarks = append(placemarks, pm)
+	}
+
+	if len(placemarks) == 0 {
+		return nil, errors.New("KML document contains no Placemarks")
+	}
+	return placemarks, nil
+}
+
+func respondKML(w http.ResponseWriter, geofences []Geofence, startTime time.Time) {
+	doc := kmlDocument{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Name:  "Geofences",
+	}
+	for _, g := range geofences {
+		doc.Placemarks = append(doc.Placemarks, geofenceToKML(g))
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="geofences.kml"`)
+	w.Header().Set("X-Time-Ns", fmt.Sprintf("%d", time.Since(startTime).Nanoseconds()))
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	w.Write(out)
+}
+
+func geofenceToKML(g Geofence) kmlPlacemark {
+	pm := kmlPlacemark{
+		ID:          g.ID,
+		Name:        g.Name,
+		Description: g.Description,
+		ExtendedData: []kmlData{
+			{Name: "category", Value: g.Category},
+			{Name: "shape", Value: g.Shape},
+		},
+	}
+
+	switch g.Shape {
+	case shapeCircle:
+		if g.Center != nil {
+			pm.Point = &kmlCoordinates{Coordinates: formatKMLCoordinates([][2]float64{*g.Center})}
+		}
+		pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: "radius_m", Value: strconv.FormatFloat(g.RadiusM, 'f', -1, 64)})
+	case shapeCorridor:
+		pm.LineString = &kmlCoordinates{Coordinates: formatKMLCoordinates(g.Coordinates)}
+		pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: "width_m", Value: strconv.FormatFloat(g.WidthM, 'f', -1, 64)})
+	case shapeMultiPolygon:
+		multi := &kmlMultiGeometry{}
+		for _, rings := range g.Polygons {
+			multi.Polygons = append(multi.Polygons, kmlPolygonFromRings(rings))
+		}
+		pm.MultiGeometry = multi
+	default:
+		polygon := kmlPolygonFromRings(append([][][2]float64{g.Coordinates}, g.Holes...))
+		pm.Polygon = &polygon
+	}
+
+	// Tags and metadata keep their JSON form so that metadata values keep
+	// their types.
+	if len(g.Tags) > 0 {
+		b, _ := json.Marshal(g.Tags)
+		pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: "tags", Value: string(b)})
+	}
+	if len(g.Metadata) > 0 {
+		b, _ := json.Marshal(g.Metadata)
+		pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: "metadata", Value: string(b)})
+	}
+
+	return pm
+}
+
+func geofenceFromKML(pm kmlPlacemark) (Geofence, error) {
+	g := Geofence{
+		Name:        strings.TrimSpace(pm.Name),
+		Description: strings.TrimSpace(pm.Description),
+	}
+
+	data := make(map[string]string)
+	for _, d := range pm.ExtendedData {
+		data[d.Name] = strings.TrimSpace(d.Value)
+	}
+	g.Category = data["category"]
+	if v := data["tags"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &g.Tags); err != nil {
+			return g, errors.New("ExtendedData tags must be a JSON array of strings")
+		}
+	}
+	if v := data["metadata"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &g.Metadata); err != nil {
+			return g, errors.New("ExtendedData metadata must be a JSON object")
+		}
+	}
+
+	switch {
+	case pm.Polygon != nil:
+		rings, err := kmlPolygonRings(*pm.Polygon)
+		if err != nil {
+			return g, err
+		}
+		g.Shape = shapePolygon
+		g.Coordinates = rings[0]
+		g.Holes = rings[1:]
+	case pm.MultiGeometry != nil && len(pm.MultiGeometry.Polygons) > 0:
+		g.Shape = shapeMultiPolygon
+		for _, polygon := range pm.MultiGeometry.Polygons {
+			rings, err := kmlPolygonRings(polygon)
+			if err != nil {
+				return g, err
+			}
+			g.Polygons = append(g.Polygons, rings)
+		}
+	case pm.Point != nil:
+		points, err := parseKMLCoordinates(pm.Point.Coordinates)
+		if err != nil {
+			return g, err
+		}
+		if len(points) != 1 {
+			return g, errors.New("Point must have exactly one coordinate")
+		}
+		g.Shape = shapeCircle
+		g.Center = &points[0]
+		g.RadiusM, _ = strconv.ParseFloat(data["radius_m"], 64)
+	case pm.LineString != nil:
+		path, err := parseKMLCoordinates(pm.LineString.Coordinates)
+		if err != nil {
+			return g, err
+		}
+		g.Shape = shapeCorridor
+		g.Coordinates = path
+		g.WidthM, _ = strconv.ParseFloat(data["width_m"], 64)
+	default:
+		return g, errors.New("placemark has no Polygon, MultiGeometry, Point or LineString geometry")
+	}
+
+	return g, nil
+}
+
+func kmlPolygonFromRings(rings [][][2]float64) kmlPolygon {
+	var polygon kmlPolygon
+	for i, ring := range rings {
+		boundary := kmlBoundary{Coordinates: formatKMLCoordinates(ring)}
+		if i == 0 {
+			polygon.Outer = boundary
+		} else {
+			polygon.Inner = append(polygon.Inner, boundary)
+		}
+	}
+	return polygon
+}
+
+func kmlPolygonRings(polygon kmlPolygon) ([][][2]float64, error) {
+	outer, err := parseKMLCoordinates(polygon.Outer.Coordinates)
+	if err != nil {
+		return nil, err
+	}
+
+	rings := [][][2]float64{outer}
+	for _, inner := range polygon.Inner {
+		hole, err := parseKMLCoordinates(inner.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, hole)
+	}
+	return rings, nil
+}
+
+func parseKMLCoordinates(text string) ([][2]float64, error) {
+	var points [][2]float64
+	for _, tuple := range strings.Fields(text) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid KML coordinate %q", tuple)
+		}
+
+		lon, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KML longitude %q", parts[0])
+		}
+		lat, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KML latitude %q", parts[1])
+		}
+		points = append(points, [2]float64{lat, lon})
+	}
+	return points, nil
+}
+
+func formatKMLCoordinates(points [][2]float64) string {
+	tuples := make([]string, len(points))
+	for i, p := range points {
+		tuples[i] = strconv.FormatFloat(p[1], 'f', -1, 64) + "," + strconv.FormatFloat(p[0], 'f', -1, 64)
+	}
+	return strings.Join(tuples, " ")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestKMLRoundTrip(t *testing.T) {
+	tests := []Geofence{
+		{
+			Name: "Depot", Description: "Main depot", Category: "delivery_zone", Shape: shapePolygon,
+			Coordinates: square(0, 0, 1, 1), Holes: [][][2]float64{square(0.4, 0.4, 0.6, 0.6)},
+			Tags:     []string{"night-shift", "priority"},
+			Metadata: map[string]interface{}{"depot": "D-14", "capacity": 40.0, "open": true},
+		},
+		{
+			Name: "Harbor", Category: "restricted_zone", Shape: shapeCircle,
+			Center: &[2]float64{37.8, -122.4}, RadiusM: 250,
+			Tags: []string{"port"},
+		},
+		{
+			Name: "Route 9", Category: "toll_zone", Shape: shapeCorridor,
+			Coordinates: [][2]float64{{0, 0}, {0, 1}}, WidthM: 30,
+			Metadata: map[string]interface{}{"lanes": 2.0},
+		},
+	}
+
+	for _, want := range tests {
+		out, err := xml.Marshal(kmlDocument{Placemarks: []kmlPlacemark{geofenceToKML(want)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		placemarks, err := decodeKMLPlacemarks(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s: %v", want.Name, err)
+		}
+		got, err := geofenceFromKML(placemarks[0])
+		if err != nil {
+			t.Fatalf("%s: %v", want.Name, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip = %+v, want %+v", want.Name, got, want)
+		}
+	}
+}
+
+func TestGeofenceFromKMLRejectsInvalidTags(t *testing.T) {
+	pm := kmlPlacemark{
+		Name:         "Bad tags",
+		ExtendedData: []kmlData{{Name: "tags", Value: "night-shift"}},
+		Point:        &kmlCoordinates{Coordinates: "0,0"},
+	}
+	if _, err := geofenceFromKML(pm); err == nil {
+		t.Error("expected an error for tags that are not a JSON array")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxBatchLocations = 1000
+
+type locationReport struct {
+	VehicleID string  `json:"vehicle_id"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timestamp string  `json:"timestamp"`
+}
+
+// insertLocations stores the reports with a single multi-row insert.
+func insertLocations(reports []locationReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO locations (id, vehicle_id, latitude, longitude, timestamp) VALUES `)
+	args := make([]interface{}, 0, len(reports)*5)
+	for i, l := range reports {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, "loc_"+uuid.New().String(), l.VehicleID, l.Latitude, l.Longitude, l.Timestamp)
+	}
+
+	_, err := db.Exec(sb.String(), args...)
+	return err
+}
+
+// processLocation evaluates a stored location report against the geofences
+// and fires any entry, exit and group alerts it causes, including
+// pass-throughs of geofences crossed since the previous report. A report
+// older than the last one evaluated for the vehicle is out of order:
+// comparing it with the newer geofence state would produce a bogus exit and
+// re-entry, so it only reports the geofences it falls in and leaves
+// transitions alone.
+func processLocation(l locationReport) (current []CurrentGeofence, outOfOrder bool) {
+	at := parseTimestamp(l.Timestamp)
+	current = checkGeofences(l.VehicleID, l.Latitude, l.Longitude, at)
+
+	prev, inOrder, err := advanceLastEvaluated(l, at)
+	if err != nil {
+		log.Println("Error updating last evaluated location:", err)
+	} else if !inOrder {
+		return current, true
+	}
+
+	if prev != nil {
+		checkPassThroughs(l.VehicleID, *prev, evaluatedPoint{l.Latitude, l.Longitude, at}, current)
+	}
+	checkAndTriggerAlerts(l.VehicleID, l.Latitude, l.Longitude, l.Timestamp, current)
+	return current, false
+}
+
+type evaluatedPoint struct {
+	lat, lon float64
+	at       time.Time
+}
+
+// advanceLastEvaluated records the report as the vehicle's last evaluated
+// location and returns the one it replaces, if any. It reports false when
+// the report is older than the last evaluated one; reports with equal
+// timestamps are both treated as in order.
+func advanceLastEvaluated(l locationReport, at time.Time) (*evaluatedPoint, bool, error) {
+	var prevAt sql.NullTime
+	var prevLat, prevLon sql.NullFloat64
+	err := db.QueryRow(
+		`UPDATE vehicles v
+		 SET last_evaluated_at = $2, last_evaluated_latitude = $3, last_evaluated_longitude = $4
+		 FROM (SELECT id, last_evaluated_at, last_evaluated_latitude, last_evaluated_longitude
+		       FROM vehicles WHERE id = $1 FOR UPDATE) prev
+		 WHERE v.id = prev.id AND (prev.last_evaluated_at IS NULL OR prev.last_evaluated_at <= $2)
+		 RETURNING prev.last_evaluated_at, prev.last_evaluated_latitude, prev.last_evaluated_longitude`,
+		l.VehicleID, sqlTimestamp(at), l.Latitude, l.Longitude,
+	).Scan(&prevAt, &prevLat, &prevLon)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !prevAt.Valid || !prevLat.Valid || !prevLon.Valid {
+		return nil, true, nil
+	}
+	return &evaluatedPoint{prevLat.Float64, prevLon.Float64, prevAt.Time}, true, nil
+}
+
+// existingVehicleIDs returns which of the given vehicle IDs are registered.
+func existingVehicleIDs(ids []string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+
+	rows, err := db.Query(`SELECT id FROM vehicles WHERE id IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		found[id] = true
+	}
+	return found, rows.Err()
+}
+
+// batchUpdateVehicleLocations ingests buffered location reports for any
+// number of vehicles. Valid reports are stored in one insert and then
+// evaluated per vehicle in timestamp order, so transitions and alerts come
+// out as if the reports had arrived one by one. Invalid reports are
+// rejected individually without failing the rest of the batch.
+func batchUpdateVehicleLocations(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	var req struct {
+		Locations []locationReport `json:"locations"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Locations) == 0 || len(req.Locations) > maxBatchLocations {
+		http.Error(w, fmt.Sprintf("locations must contain between 1 and %d reports", maxBatchLocations), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]map[string]interface{}, len(req.Locations))
+	times := make([]time.Time, len(req.Locations))
+	var vehicleIDs []string
+	seen := make(map[string]bool)
+	for i, l := range req.Locations {
+		var errs validationErrors
+		if l.VehicleID == "" {
+			errs.add("vehicle_id", "is required")
+		}
+		validatePoint(&errs, [2]float64{l.Latitude, l.Longitude}, "location")
+		t, err := time.Parse(time.RFC3339Nano, l.Timestamp)
+		if err != nil {
+			errs.add("timestamp", "must be an ISO 8601 date-time")
+		}
+		times[i] = t
+
+		results[i] = map[string]interface{}{
+			"index":      i,
+			"vehicle_id": l.VehicleID,
+			"stored":     false,
+		}
+		if len(errs) > 0 {
+			results[i]["error"] = "validation failed"
+			results[i]["errors"] = errs
+			continue
+		}
+		if !seen[l.VehicleID] {
+			seen[l.VehicleID] = true
+			vehicleIDs = append(vehicleIDs, l.VehicleID)
+		}
+	}
+
+	known, err := existingVehicleIDs(vehicleIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var accepted []int
+	for i, l := range req.Locations {
+		if _, failed := results[i]["error"]; failed {
+			continue
+		}
+		if !known[l.VehicleID] {
+			results[i]["error"] = "vehicle not found"
+			continue
+		}
+		accepted = append(accepted, i)
+	}
+
+	reports := make([]locationReport, len(accepted))
+	for n, i := range accepted {
+		reports[n] = req.Locations[i]
+	}
+	if err := insertLocations(reports); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(accepted, func(a, b int) bool {
+		ia, ib := accepted[a], accepted[b]
+		if req.Locations[ia].VehicleID != req.Locations[ib].VehicleID {
+			return req.Locations[ia].VehicleID < req.Locations[ib].VehicleID
+		}
+		return times[ia].Before(times[ib])
+	})
+	for _, i := range accepted {
+		current, outOfOrder := processLocation(req.Locations[i])
+		results[i]["stored"] = true
+		results[i]["current_geofences"] = current
+		results[i]["out_of_order"] = outOfOrder
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"accepted": len(accepted),
+		"rejected": len(req.Locations) - len(accepted),
+		"results":  results,
+	}, startTime)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -17,7 +18,9 @@ import (
 
 var db *sql.DB
 
-func init() {
+// connectDB opens the database, creates the schema and loads the geofence
+// index.
+func connectDB() {
 	var err error
 	dsn := os.Getenv("DATABASE_URL")
 	fmt.Println("Database URL:", dsn)
@@ -36,6 +39,7 @@ func init() {
 
 	log.Println("Connected to database")
 	initDB()
+	geofenceIndex.ensureLoaded()
 }
 
 func initDB() {
@@ -115,15 +119,98 @@ func initDB() {
 	PRIMARY KEY (vehicle_id, geofence_id)
 	);
 
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS shape VARCHAR(20) NOT NULL DEFAULT 'polygon';
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS center_latitude DECIMAL(10, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS center_longitude DECIMAL(11, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS radius_m DOUBLE PRECISION;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS width_m DOUBLE PRECISION;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS holes TEXT;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS polygons TEXT;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS hysteresis_m DOUBLE PRECISION NOT NULL DEFAULT 0;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS schedule TEXT;
+
+	CREATE TABLE IF NOT EXISTS geofence_versions (
+		geofence_id VARCHAR(50) NOT NULL,
+		version INTEGER NOT NULL,
+		definition TEXT NOT NULL,
+		valid_from TIMESTAMP NOT NULL,
+		valid_to TIMESTAMP,
+		PRIMARY KEY (geofence_id, version),
+		FOREIGN KEY (geofence_id) REFERENCES geofences(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS categories (
+		id VARCHAR(50) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		severity VARCHAR(20) NOT NULL DEFAULT 'medium',
+		color VARCHAR(7) NOT NULL DEFAULT '#607d8b',
+		default_event_type VARCHAR(20),
+		strict_overlap BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS geofence_groups (
+		id VARCHAR(50) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		parent_id VARCHAR(50),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (parent_id) REFERENCES geofence_groups(id)
+	);
+
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS group_id VARCHAR(50) REFERENCES geofence_groups(id);
+	ALTER TABLE alert_configs ADD COLUMN IF NOT EXISTS group_id VARCHAR(50) REFERENCES geofence_groups(id);
+	ALTER TABLE alert_configs ALTER COLUMN geofence_id DROP NOT NULL;
+	ALTER TABLE violations ADD COLUMN IF NOT EXISTS group_id VARCHAR(50);
+
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS area_m2 DOUBLE PRECISION;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS perimeter_m DOUBLE PRECISION;
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS centroid_latitude DECIMAL(10, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS centroid_longitude DECIMAL(11, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS bbox_min_lat DECIMAL(10, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS bbox_min_lon DECIMAL(11, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS bbox_max_lat DECIMAL(10, 8);
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS bbox_max_lon DECIMAL(11, 8);
+	ALTER TABLE alert_history ADD COLUMN IF NOT EXISTS group_id VARCHAR(50);
+
+	ALTER TABLE violations ADD COLUMN IF NOT EXISTS geofence_version INTEGER;
+	ALTER TABLE alert_configs ADD COLUMN IF NOT EXISTS dwell_seconds INTEGER;
+	ALTER TABLE vehicle_geofence_state ADD COLUMN IF NOT EXISTS entered_at TIMESTAMP;
+	ALTER TABLE vehicle_geofence_state ADD COLUMN IF NOT EXISTS dwell_alerted_seconds INTEGER NOT NULL DEFAULT 0;
+
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+	ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';
+	ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+	ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS last_evaluated_at TIMESTAMP;
+	ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS last_evaluated_latitude DOUBLE PRECISION;
+	ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS last_evaluated_longitude DOUBLE PRECISION;
+	ALTER TABLE violations ADD COLUMN IF NOT EXISTS pass_through BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE alert_history ADD COLUMN IF NOT EXISTS pass_through BOOLEAN NOT NULL DEFAULT FALSE;
+
 	CREATE INDEX IF NOT EXISTS idx_vehicle_id ON locations(vehicle_id);
 	CREATE INDEX IF NOT EXISTS idx_geofence_id ON violations(geofence_id);
 	CREATE INDEX IF NOT EXISTS idx_vehicle_id_violations ON violations(vehicle_id);
+	CREATE INDEX IF NOT EXISTS idx_geofences_group_id ON geofences(group_id);
+	CREATE INDEX IF NOT EXISTS idx_geofence_groups_parent_id ON geofence_groups(parent_id);
+	CREATE INDEX IF NOT EXISTS idx_geofences_tags ON geofences USING GIN (tags);
+	CREATE INDEX IF NOT EXISTS idx_geofences_metadata ON geofences USING GIN (metadata);
+	CREATE INDEX IF NOT EXISTS idx_vehicles_tags ON vehicles USING GIN (tags);
+	CREATE INDEX IF NOT EXISTS idx_vehicles_metadata ON vehicles USING GIN (metadata);
 	`
 
 	_, err := db.Exec(schema)
 	if err != nil {
 		log.Fatal("Failed to create schema:", err)
 	}
+	seedCategories()
+	backfillGeofenceMetrics()
+	backfillGeofenceVersions()
+	if postgisEnabled {
+		initPostGIS()
+	} else {
+		detectGeofenceGeography()
+	}
 	log.Println("Database schema initialized")
 }
 
@@ -148,6 +235,8 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}, startTime
 }
 
 func main() {
+	connectDB()
+
 	r := chi.NewRouter()
 
 	r.Use(func(next http.Handler) http.Handler {
@@ -155,7 +244,7 @@ func main() {
 			if r.Method == http.MethodOptions {
 				w.Header().Set("Access-Control-Allow-Origin",
 					"https://6955daf9f484350008b7ac67--lambent-halva-ca8340.netlify.app")
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 				w.WriteHeader(http.StatusNoContent)
 				return
@@ -166,7 +255,7 @@ func main() {
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
@@ -177,16 +266,42 @@ func main() {
 
 	r.Post("/geofences", createGeofence)
 	r.Get("/geofences", getGeofences)
+	r.Post("/geofences/import", importGeofences)
+	r.Post("/geofences/import/kml", importGeofencesKML)
+	r.Get("/geofences/nearby", getNearbyGeofences)
+	r.Get("/geofences/contains", getContainingGeofences)
+	r.Post("/geofences/contains", postContainingGeofences)
+	r.Get("/geofences/{geofenceID}", getGeofence)
+	r.Put("/geofences/{geofenceID}", updateGeofence)
+	r.Patch("/geofences/{geofenceID}", updateGeofence)
+	r.Delete("/geofences/{geofenceID}", deleteGeofence)
+	r.Get("/geofences/{geofenceID}/versions", getGeofenceVersions)
+	r.Get("/geofences/{geofenceID}/evaluate", evaluateGeofenceHistory)
+	r.Post("/categories", createCategory)
+	r.Get("/categories", getCategories)
+	r.Get("/categories/{categoryID}", getCategory)
+	r.Put("/categories/{categoryID}", updateCategory)
+	r.Patch("/categories/{categoryID}", updateCategory)
+	r.Delete("/categories/{categoryID}", deleteCategory)
+	r.Post("/groups", createGroup)
+	r.Get("/groups", getGroups)
+	r.Get("/groups/{groupID}", getGroup)
+	r.Put("/groups/{groupID}", updateGroup)
+	r.Patch("/groups/{groupID}", updateGroup)
+	r.Delete("/groups/{groupID}", deleteGroup)
 	r.Post("/vehicles", registerVehicle)
 	r.Get("/vehicles", getVehicles)
+	r.Patch("/vehicles/{vehicleID}", updateVehicle)
 	r.Post("/vehicles/location", updateVehicleLocation)
+	r.Post("/vehicles/locations/batch", batchUpdateVehicleLocations)
 	r.Get("/vehicles/location/{vehicleID}", getVehicleLocation)
 	r.Post("/alerts/configure", configureAlert)
 	r.Get("/alerts", getAlerts)
 	r.Get("/violations/history", getViolationsHistory)
 
-	hub := NewAlertHub()
+	hub = NewAlertHub()
 	go hub.Run()
+	go runDwellEvaluator(dwellCheckInterval())
 	r.HandleFunc("/ws/alerts", func(w http.ResponseWriter, r *http.Request) {
 		handleWebSocket(hub, w, r)
 	})
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const maxTagLength = 64
+
+// validateTags trims and de-duplicates tags, keeping their order.
+func validateTags(errs *validationErrors, tags []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+			errs.add(fmt.Sprintf("tags[%d]", i), "must not be empty")
+		case len(tag) > maxTagLength:
+			errs.add(fmt.Sprintf("tags[%d]", i), "must be at most %d characters", maxTagLength)
+		case !seen[tag]:
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+func validateMetadata(errs *validationErrors, metadata map[string]interface{}) {
+	for key := range metadata {
+		if strings.TrimSpace(key) == "" {
+			errs.add("metadata", "keys must not be empty")
+		}
+	}
+}
+
+// decodeUpdate decodes a request body onto v, whose metadata map is
+// *metadata. encoding/json would merge a metadata object into the existing
+// map key by key, so the map is dropped first when the body gives metadata
+// and it is replaced like every other field.
+func decodeUpdate(body io.Reader, v interface{}, metadata *map[string]interface{}) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	var fields struct {
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if fields.Metadata != nil {
+		*metadata = nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// tagsAndMetadataValues returns the stored JSON form of tags and metadata.
+func tagsAndMetadataValues(tags []string, metadata map[string]interface{}) (string, string) {
+	if tags == nil {
+		tags = []string{}
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	tagsJSON, _ := json.Marshal(tags)
+	metaJSON, _ := json.Marshal(metadata)
+	return string(tagsJSON), string(metaJSON)
+}
+
+func parseTagsAndMetadata(tagsJSON, metaJSON []byte) ([]string, map[string]interface{}) {
+	var tags []string
+	var metadata map[string]interface{}
+	json.Unmarshal(tagsJSON, &tags)
+	json.Unmarshal(metaJSON, &metadata)
+	if len(tags) == 0 {
+		tags = nil
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	return tags, metadata
+}
+
+func orEmptyTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+func orEmptyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return map[string]interface{}{}
+	}
+	return metadata
+}
+
+// tagMetadataFilter turns ?tag=x (repeatable, all must match) and
+// ?meta.key=value query parameters into SQL conditions on the tags and
+// metadata columns, numbering placeholders after the existing args.
+func tagMetadataFilter(q url.Values, args []interface{}) (string, []interface{}) {
+	var sb strings.Builder
+
+	for _, tag := range q["tag"] {
+		b, _ := json.Marshal([]string{tag})
+		args = append(args, string(b))
+		fmt.Fprintf(&sb, " AND tags @> $%d::jsonb", len(args))
+	}
+	for param, values := range q {
+		key := strings.TrimPrefix(param, "meta.")
+		if key == param || key == "" {
+			continue
+		}
+		for _, value := range values {
+			args = append(args, key, value)
+			fmt.Fprintf(&sb, " AND metadata ->> $%d = $%d", len(args)-1, len(args))
+		}
+	}
+	return sb.String(), args
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeUpdateReplacesMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want map[string]interface{}
+	}{
+		{"metadata omitted", `{"name": "depot"}`, map[string]interface{}{"a": "1", "b": "2"}},
+		{"metadata replaced", `{"metadata": {"b": "3"}}`, map[string]interface{}{"b": "3"}},
+		{"metadata emptied", `{"metadata": {}}`, map[string]interface{}{}},
+		{"metadata null", `{"metadata": null}`, nil},
+	}
+	for _, tt := range tests {
+		g := Geofence{Metadata: map[string]interface{}{"a": "1", "b": "2"}}
+		if err := decodeUpdate(strings.NewReader(tt.body), &g, &g.Metadata); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(g.Metadata, tt.want) {
+			t.Errorf("%s: metadata = %v, want %v", tt.name, g.Metadata, tt.want)
+		}
+	}
+}
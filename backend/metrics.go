@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+)
+
+// computeMetrics sets the geodesic area, perimeter, centroid and bounding
+// box of the geofence. Circles and corridors use their exact buffer shape:
+// a spherical cap and a path buffered by WidthM with round ends (overlap of
+// a self-crossing path is counted twice).
+func (g *Geofence) computeMetrics() {
+	g.AreaM2, g.PerimeterM, g.Centroid, g.BBox = 0, 0, nil, nil
+
+	switch g.Shape {
+	case shapeCircle:
+		if g.Center == nil {
+			return
+		}
+		angle := g.RadiusM / earthRadiusM
+		g.AreaM2 = 2 * math.Pi * earthRadiusM * earthRadiusM * (1 - math.Cos(angle))
+		g.PerimeterM = 2 * math.Pi * earthRadiusM * math.Sin(angle)
+		center := *g.Center
+		g.Centroid = &center
+	case shapeCorridor:
+		if len(g.Coordinates) == 0 {
+			return
+		}
+		length := pathLengthMeters(g.Coordinates)
+		g.AreaM2 = 2*g.WidthM*length + math.Pi*g.WidthM*g.WidthM
+		g.PerimeterM = 2*length + 2*math.Pi*g.WidthM
+		g.Centroid = pathCentroid(g.Coordinates)
+	default:
+		rings := g.polygonRings()
+		if len(rings) == 0 || len(rings[0]) == 0 || len(rings[0][0]) == 0 {
+			return
+		}
+
+		origin := rings[0][0][0]
+		var sumX, sumY, sumArea float64
+		for _, polygon := range rings {
+			for i, ring := range polygon {
+				x, y, planarArea := ringCentroid(origin, ring)
+				area := sphericalRingArea(ring)
+				if i > 0 {
+					planarArea, area = -planarArea, -area
+				}
+				sumX += x * planarArea
+				sumY += y * planarArea
+				sumArea += planarArea
+				g.AreaM2 += area
+				g.PerimeterM += pathLengthMeters(ring)
+			}
+		}
+		if sumArea > 0 {
+			lat, lon := unprojectMeters(origin[0], origin[1], sumX/sumArea, sumY/sumArea)
+			g.Centroid = &[2]float64{lat, lon}
+		}
+	}
+
+	b := g.bounds()
+	if b.MinLon < -180 {
+		b.MinLon += 360
+	}
+	if b.MaxLon > 180 {
+		b.MaxLon -= 360
+	}
+	g.BBox = &b
+}
+
+// pathCentroid returns the length-weighted midpoint of a path's segments.
+func pathCentroid(path [][2]float64) *[2]float64 {
+	origin := path[0]
+	var sumX, sumY, sumLength float64
+	for i := 1; i < len(path); i++ {
+		x1, y1 := projectMeters(origin[0], origin[1], path[i-1][0], path[i-1][1])
+		x2, y2 := projectMeters(origin[0], origin[1], path[i][0], path[i][1])
+		length := math.Hypot(x2-x1, y2-y1)
+		sumX += (x1 + x2) / 2 * length
+		sumY += (y1 + y2) / 2 * length
+		sumLength += length
+	}
+	if sumLength == 0 {
+		return &[2]float64{origin[0], origin[1]}
+	}
+	lat, lon := unprojectMeters(origin[0], origin[1], sumX/sumLength, sumY/sumLength)
+	return &[2]float64{lat, lon}
+}
+
+// backfillGeofenceMetrics computes the metrics of geofences stored before
+// they were tracked.
+func backfillGeofenceMetrics() {
+	rows, err := db.Query("SELECT " + geofenceColumns + " FROM geofences WHERE area_m2 IS NULL")
+	if err != nil {
+		log.Println("Error loading geofences for metrics backfill:", err)
+		return
+	}
+
+	var geofences []Geofence
+	for rows.Next() {
+		g, err := scanGeofence(rows)
+		if err != nil {
+			log.Println("Error scanning geofence:", err)
+			continue
+		}
+		geofences = append(geofences, g)
+	}
+	rows.Close()
+
+	for _, g := range geofences {
+		g.computeMetrics()
+		args := append([]interface{}{g.ID}, metricsValues(&g)...)
+		_, err := db.Exec(
+			`UPDATE geofences SET area_m2 = $2, perimeter_m = $3, centroid_latitude = $4, centroid_longitude = $5,
+			bbox_min_lat = $6, bbox_min_lon = $7, bbox_max_lat = $8, bbox_max_lon = $9
+			WHERE id = $1`,
+			args...,
+		)
+		if err != nil {
+			log.Println("Error backfilling geofence metrics:", err)
+		}
+	}
+}
+
+func metricsValues(g *Geofence) []interface{} {
+	var centroidLat, centroidLon sql.NullFloat64
+	if g.Centroid != nil {
+		centroidLat = sql.NullFloat64{Float64: g.Centroid[0], Valid: true}
+		centroidLon = sql.NullFloat64{Float64: g.Centroid[1], Valid: true}
+	}
+	var b boundingBox
+	if g.BBox != nil {
+		b = *g.BBox
+	}
+	return []interface{}{g.AreaM2, g.PerimeterM, centroidLat, centroidLon, b.MinLat, b.MinLon, b.MaxLat, b.MaxLon}
+}
+
+// setMetrics fills the metrics from their stored columns, in the order of
+// metricsValues.
+func setMetrics(g *Geofence, m [8]sql.NullFloat64) {
+	if !m[0].Valid {
+		return
+	}
+	g.AreaM2, g.PerimeterM = m[0].Float64, m[1].Float64
+	if m[2].Valid && m[3].Valid {
+		g.Centroid = &[2]float64{m[2].Float64, m[3].Float64}
+	}
+	g.BBox = &boundingBox{MinLat: m[4].Float64, MinLon: m[5].Float64, MaxLat: m[6].Float64, MaxLon: m[7].Float64}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func within(got, want, relTol float64) bool {
+	return math.Abs(got-want) <= math.Abs(want)*relTol
+}
+
+func TestComputeMetricsArea(t *testing.T) {
+	// Area between two parallels over a longitude span, on the sphere.
+	band := func(lat0, lat1, dLon float64) float64 {
+		return earthRadiusM * earthRadiusM * toRadians(dLon) * (math.Sin(toRadians(lat1)) - math.Sin(toRadians(lat0)))
+	}
+
+	tests := []struct {
+		name          string
+		g             *Geofence
+		wantArea      float64
+		wantPerimeter float64
+	}{
+		{
+			"one degree square at the equator",
+			&Geofence{Shape: shapePolygon, Coordinates: square(0, 0, 1, 1)},
+			band(0, 1, 1),
+			4 * 111195,
+		},
+		{
+			"one degree square at 60°N",
+			&Geofence{Shape: shapePolygon, Coordinates: square(60, 10, 61, 11)},
+			band(60, 61, 1),
+			0,
+		},
+		{
+			"square with a hole",
+			&Geofence{Shape: shapePolygon, Coordinates: square(0, 0, 1, 1), Holes: [][][2]float64{square(0.25, 0.25, 0.75, 0.75)}},
+			band(0, 1, 1) - band(0.25, 0.75, 0.5),
+			6 * 111195,
+		},
+		{
+			"multipolygon",
+			&Geofence{Shape: shapeMultiPolygon, Polygons: [][][][2]float64{{square(0, 0, 1, 1)}, {square(0, 2, 1, 3)}}},
+			2 * band(0, 1, 1),
+			0,
+		},
+		{
+			"square across the dateline",
+			&Geofence{Shape: shapePolygon, Coordinates: datelineSquare},
+			band(-10, 10, 2),
+			0,
+		},
+		{
+			"circle",
+			&Geofence{Shape: shapeCircle, Center: &[2]float64{45, 7}, RadiusM: 1000},
+			math.Pi * 1000 * 1000,
+			2 * math.Pi * 1000,
+		},
+		{
+			"corridor",
+			&Geofence{Shape: shapeCorridor, Coordinates: [][2]float64{{0, 0}, {0, 0.1}}, WidthM: 50},
+			2*50*11119.5 + math.Pi*50*50,
+			2*11119.5 + 2*math.Pi*50,
+		},
+	}
+	for _, tt := range tests {
+		tt.g.computeMetrics()
+		if !within(tt.g.AreaM2, tt.wantArea, 0.001) {
+			t.Errorf("%s: area = %.0f m², want %.0f m²", tt.name, tt.g.AreaM2, tt.wantArea)
+		}
+		if tt.wantPerimeter > 0 && !within(tt.g.PerimeterM, tt.wantPerimeter, 0.001) {
+			t.Errorf("%s: perimeter = %.0f m, want %.0f m", tt.name, tt.g.PerimeterM, tt.wantPerimeter)
+		}
+	}
+}
+
+func TestComputeMetricsCentroidAndBounds(t *testing.T) {
+	tests := []struct {
+		name         string
+		g            *Geofence
+		wantCentroid [2]float64
+		wantBBox     boundingBox
+	}{
+		{
+			"square",
+			&Geofence{Shape: shapePolygon, Coordinates: square(0, 0, 1, 1)},
+			[2]float64{0.5, 0.5},
+			boundingBox{0, 0, 1, 1},
+		},
+		{
+			// Removing the north-east quarter moves the centroid away from it
+			// to (5/12, 5/12) of the way across.
+			"square missing its north-east quarter",
+			&Geofence{Shape: shapePolygon, Coordinates: [][2]float64{{0, 0}, {0, 1}, {0.5, 1}, {0.5, 0.5}, {1, 0.5}, {1, 0}, {0, 0}}},
+			[2]float64{5.0 / 12, 5.0 / 12},
+			boundingBox{0, 0, 1, 1},
+		},
+		{
+			"square across the dateline",
+			&Geofence{Shape: shapePolygon, Coordinates: datelineSquare},
+			[2]float64{0, 180},
+			boundingBox{-10, 179, 10, -179},
+		},
+		{
+			"corridor",
+			&Geofence{Shape: shapeCorridor, Coordinates: [][2]float64{{0, 0}, {0, 0.2}, {0.2, 0.2}}, WidthM: 10},
+			[2]float64{0.05, 0.15},
+			boundingBox{0, 0, 0.2, 0.2},
+		},
+	}
+	for _, tt := range tests {
+		tt.g.computeMetrics()
+		if tt.g.Centroid == nil {
+			t.Errorf("%s: no centroid", tt.name)
+			continue
+		}
+		c := *tt.g.Centroid
+		if math.Abs(c[0]-tt.wantCentroid[0]) > 0.01 || math.Abs(normalizeLonDelta(c[1]-tt.wantCentroid[1])) > 0.01 {
+			t.Errorf("%s: centroid = %v, want %v", tt.name, c, tt.wantCentroid)
+		}
+
+		b := *tt.g.BBox
+		if tt.g.Shape == shapeCorridor {
+			// The corridor's box includes its width around the path.
+			if b.MinLat > tt.wantBBox.MinLat || b.MaxLat < tt.wantBBox.MaxLat || b.MinLon > tt.wantBBox.MinLon || b.MaxLon < tt.wantBBox.MaxLon {
+				t.Errorf("%s: bbox = %+v does not cover %+v", tt.name, b, tt.wantBBox)
+			}
+			continue
+		}
+		if b != tt.wantBBox {
+			t.Errorf("%s: bbox = %+v, want %+v", tt.name, b, tt.wantBBox)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// overlapSamples is the number of sample rows and columns used to
+	// estimate the area shared by two geofences.
+	overlapSamples = 100
+
+	// overlapProbeM is how far from a boundary geofencesOverlap looks for
+	// points inside both geofences.
+	overlapProbeM = 0.01
+)
+
+type GeofenceOverlap struct {
+	GeofenceID    string  `json:"geofence_id"`
+	GeofenceName  string  `json:"geofence_name"`
+	Category      string  `json:"category"`
+	OverlapAreaM2 float64 `json:"overlap_area_m2"`
+}
+
+// checkOverlaps finds the active geofences that overlap g, largest overlap
+// first. It returns validation errors when g or an overlapped geofence
+// belongs to a category with strict_overlap set.
+func checkOverlaps(g *Geofence) ([]GeofenceOverlap, error) {
+	overlaps := []GeofenceOverlap{}
+	strictCategories := strictOverlapCategories()
+	var errs validationErrors
+
+	for _, other := range geofenceIndex.intersecting(g.boxes()) {
+		if other.ID == g.ID {
+			continue
+		}
+		if !geofencesOverlap(g, other) {
+			continue
+		}
+		area := overlapAreaM2(g, other)
+
+		overlaps = append(overlaps, GeofenceOverlap{
+			GeofenceID:    other.ID,
+			GeofenceName:  other.Name,
+			Category:      other.Category,
+			OverlapAreaM2: math.Round(area),
+		})
+		strict := g.Category
+		if !strictCategories[strict] {
+			strict = other.Category
+		}
+		if strictCategories[strict] {
+			errs.add("overlaps", "overlaps geofence %s (%s, %s) by about %.0f m², which is not allowed for %s geofences",
+				other.ID, other.Name, other.Category, area, strict)
+		}
+	}
+
+	sort.Slice(overlaps, func(i, j int) bool {
+		return overlaps[i].OverlapAreaM2 > overlaps[j].OverlapAreaM2
+	})
+	if len(errs) > 0 {
+		return overlaps, errs
+	}
+	return overlaps, nil
+}
+
+// overlapAreaM2 estimates the area shared by two geofences by testing a
+// grid of sample points over the intersection of their bounding boxes.
+// Overlaps narrower than a grid cell may be estimated as 0, so whether two
+// geofences overlap is decided by geofencesOverlap instead.
+func overlapAreaM2(a, b *Geofence) float64 {
+	ba, bb := a.bounds(), b.bounds()
+
+	area := 0.0
+	for _, shift := range []float64{-360, 0, 360} {
+		box := boundingBox{
+			MinLat: math.Max(ba.MinLat, bb.MinLat),
+			MinLon: math.Max(ba.MinLon, bb.MinLon+shift),
+			MaxLat: math.Min(ba.MaxLat, bb.MaxLat),
+			MaxLon: math.Min(ba.MaxLon, bb.MaxLon+shift),
+		}
+		if box.MinLat < box.MaxLat && box.MinLon < box.MaxLon {
+			area += sampleOverlap(a, b, box)
+		}
+	}
+	return area
+}
+
+func sampleOverlap(a, b *Geofence, box boundingBox) float64 {
+	dLat := (box.MaxLat - box.MinLat) / overlapSamples
+	dLon := (box.MaxLon - box.MinLon) / overlapSamples
+
+	area := 0.0
+	for i := 0; i < overlapSamples; i++ {
+		lat0 := box.MinLat + float64(i)*dLat
+		lat := lat0 + dLat/2
+		cellArea := earthRadiusM * earthRadiusM * toRadians(dLon) *
+			(math.Sin(toRadians(lat0+dLat)) - math.Sin(toRadians(lat0)))
+
+		for j := 0; j < overlapSamples; j++ {
+			lon := normalizeLonDelta(box.MinLon + (float64(j)+0.5)*dLon)
+			if a.contains(lat, lon) && b.contains(lat, lon) {
+				area += cellArea
+			}
+		}
+	}
+	return area
+}
+
+// geofencesOverlap reports whether the interiors of two geofences share any
+// area. Geofences that only touch along an edge or at a point do not
+// overlap. Circles and corridors are compared through the distance from
+// their center or path to the other geofence.
+func geofencesOverlap(a, b *Geofence) bool {
+	pathA, bufferA := bufferedCore(a)
+	pathB, bufferB := bufferedCore(b)
+
+	switch {
+	case pathA == nil && pathB == nil:
+		rings := a.polygonRings()
+		if len(rings) == 0 || len(rings[0]) == 0 || len(rings[0][0]) == 0 {
+			return false
+		}
+		origin := rings[0][0][0]
+		planarA, planarB := projectPolygons(origin, rings), projectPolygons(origin, b.polygonRings())
+		return boundaryOverlaps(planarA, planarB) || boundaryOverlaps(planarB, planarA)
+	case pathA == nil:
+		return polygonPathDistanceMeters(a, pathB) < bufferB
+	case pathB == nil:
+		return polygonPathDistanceMeters(b, pathA) < bufferA
+	default:
+		return pathDistanceMeters(pathA, pathB) < bufferA+bufferB
+	}
+}
+
+// bufferedCore describes a circle as its center buffered by its radius and a
+// corridor as its path buffered by its width. Polygons have no core.
+func bufferedCore(g *Geofence) ([][2]float64, float64) {
+	switch g.Shape {
+	case shapeCircle:
+		if g.Center == nil {
+			return [][2]float64{}, 0
+		}
+		return [][2]float64{*g.Center}, g.RadiusM
+	case shapeCorridor:
+		return g.Coordinates, g.WidthM
+	default:
+		return nil, 0
+	}
+}
+
+// pathSegments returns the segments of a path; a single point is returned
+// as a zero-length segment.
+func pathSegments(path [][2]float64) [][2][2]float64 {
+	if len(path) == 1 {
+		return [][2][2]float64{{path[0], path[0]}}
+	}
+	segments := make([][2][2]float64, 0, len(path))
+	for i := 1; i < len(path); i++ {
+		segments = append(segments, [2][2]float64{path[i-1], path[i]})
+	}
+	return segments
+}
+
+// segmentDistanceMeters returns the distance between segments ab and cd,
+// which is zero when they touch or cross.
+func segmentDistanceMeters(a, b, c, d [2]float64) float64 {
+	project := func(p [2]float64) [2]float64 {
+		x, y := projectMeters(a[0], a[1], p[0], p[1])
+		return [2]float64{y, x}
+	}
+	if segmentsIntersect(project(a), project(b), project(c), project(d)) {
+		return 0
+	}
+	return math.Min(
+		math.Min(pointToSegmentMeters(a[0], a[1], c, d), pointToSegmentMeters(b[0], b[1], c, d)),
+		math.Min(pointToSegmentMeters(c[0], c[1], a, b), pointToSegmentMeters(d[0], d[1], a, b)),
+	)
+}
+
+func pathDistanceMeters(p, q [][2]float64) float64 {
+	best := math.Inf(1)
+	for _, s := range pathSegments(p) {
+		for _, t := range pathSegments(q) {
+			best = math.Min(best, segmentDistanceMeters(s[0], s[1], t[0], t[1]))
+		}
+	}
+	return best
+}
+
+// polygonPathDistanceMeters returns the distance from a path to a polygon
+// geofence, which is zero when the path enters it.
+func polygonPathDistanceMeters(g *Geofence, path [][2]float64) float64 {
+	for _, p := range path {
+		if g.contains(p[0], p[1]) {
+			return 0
+		}
+	}
+
+	best := math.Inf(1)
+	for _, rings := range g.polygonRings() {
+		for _, ring := range rings {
+			best = math.Min(best, pathDistanceMeters(path, ring))
+		}
+	}
+	return best
+}
+
+// projectPolygons maps polygons onto the local plane around origin, as
+// [y, x] pairs in meters. The projection is affine in latitude and
+// longitude, so straight edges stay straight and polygons crossing the
+// antimeridian become continuous.
+func projectPolygons(origin [2]float64, polygons [][][][2]float64) [][][][2]float64 {
+	out := make([][][][2]float64, len(polygons))
+	for i, rings := range polygons {
+		out[i] = make([][][2]float64, len(rings))
+		for j, ring := range rings {
+			out[i][j] = make([][2]float64, len(ring))
+			for k, p := range ring {
+				x, y := projectMeters(origin[0], origin[1], p[0], p[1])
+				out[i][j][k] = [2]float64{y, x}
+			}
+		}
+	}
+	return out
+}
+
+func inPlanarPolygons(p [2]float64, polygons [][][][2]float64) bool {
+	for _, rings := range polygons {
+		if len(rings) == 0 || len(rings[0]) == 0 || !isPointInPolygon(p[0], p[1], rings[0]) {
+			continue
+		}
+		inHole := false
+		for _, hole := range rings[1:] {
+			if len(hole) > 0 && isPointInPolygon(p[0], p[1], hole) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// boundaryOverlaps looks for area shared by two planar polygon sets along
+// the boundary of a. Any shared area is bounded partly by a's or b's
+// boundary, so checking both ways finds every overlap. A proper crossing of
+// two edges always means overlap. Otherwise each edge of a is split where
+// b's boundary touches it, and points just either side of the middle of
+// each piece are tested against both polygon sets; pieces that only touch
+// b from outside have no point inside both.
+func boundaryOverlaps(a, b [][][][2]float64) bool {
+	for _, rings := range a {
+		for _, ring := range rings {
+			for i := 0; i+1 < len(ring); i++ {
+				s, e := ring[i], ring[i+1]
+				dy, dx := e[0]-s[0], e[1]-s[1]
+				length := math.Hypot(dy, dx)
+				if length == 0 {
+					continue
+				}
+
+				cuts := []float64{0, 1}
+				for _, otherRings := range b {
+					for _, other := range otherRings {
+						for j := 0; j+1 < len(other); j++ {
+							c, d := other[j], other[j+1]
+							if sideOf(c, d, s)*sideOf(c, d, e) < 0 && sideOf(s, e, c)*sideOf(s, e, d) < 0 {
+								return true
+							}
+							for _, p := range [][2]float64{c, d} {
+								t := ((p[0]-s[0])*dy + (p[1]-s[1])*dx) / (length * length)
+								if sideOf(s, e, p) == 0 && t > 0 && t < 1 {
+									cuts = append(cuts, t)
+								}
+							}
+						}
+					}
+				}
+
+				sort.Float64s(cuts)
+				ny, nx := -dx/length*overlapProbeM, dy/length*overlapProbeM
+				for k := 1; k < len(cuts); k++ {
+					if cuts[k]-cuts[k-1] < 1e-12 {
+						continue
+					}
+					t := (cuts[k-1] + cuts[k]) / 2
+					m := [2]float64{s[0] + t*dy, s[1] + t*dx}
+					for _, q := range [][2]float64{{m[0] + ny, m[1] + nx}, {m[0] - ny, m[1] - nx}} {
+						if inPlanarPolygons(q, a) && inPlanarPolygons(q, b) {
+							return true
+						}
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// sideOf reports on which side of the line through a and b the point p
+// lies, treating points within a micrometer of the line as on it so that
+// rounding cannot turn a touching vertex into a crossing.
+func sideOf(a, b, p [2]float64) int {
+	length := math.Hypot(b[0]-a[0], b[1]-a[1])
+	if length == 0 {
+		return 0
+	}
+	d := orientation(a, b, p) / length
+	switch {
+	case d > 1e-6:
+		return 1
+	case d < -1e-6:
+		return -1
+	default:
+		return 0
+	}
+}
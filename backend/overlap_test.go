@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeofencesOverlap(t *testing.T) {
+	polygon := func(ring [][2]float64, holes ...[][2]float64) *Geofence {
+		return &Geofence{Shape: shapePolygon, Coordinates: ring, Holes: holes}
+	}
+	circle := func(lat, lon, radius float64) *Geofence {
+		return &Geofence{Shape: shapeCircle, Center: &[2]float64{lat, lon}, RadiusM: radius}
+	}
+	corridor := func(width float64, path ...[2]float64) *Geofence {
+		return &Geofence{Shape: shapeCorridor, Coordinates: path, WidthM: width}
+	}
+
+	// Two strips about 100 m wide crossing in an X; a sample grid over
+	// their bounding boxes misses the small area they share.
+	stripNE := polygon([][2]float64{{0, 0.0005}, {0.0005, 0}, {0.1005, 0.1}, {0.1, 0.1005}, {0, 0.0005}})
+	stripNW := polygon([][2]float64{{-0.0005, 0.1}, {0.1, -0.0005}, {0.1005, 0}, {0, 0.1005}, {-0.0005, 0.1}})
+	withHole := polygon(square(0, 0, 1, 1), square(0.25, 0.25, 0.75, 0.75))
+
+	tests := []struct {
+		name string
+		a, b *Geofence
+		want bool
+	}{
+		{"overlapping squares", polygon(square(0, 0, 1, 1)), polygon(square(0.5, 0.5, 1.5, 1.5)), true},
+		{"shared edge", polygon(square(0, 0, 1, 1)), polygon(square(0, 1, 1, 2)), false},
+		{"shared corner", polygon(square(0, 0, 1, 1)), polygon(square(1, 1, 2, 2)), false},
+		{"partly shared edge", polygon(square(0, 0, 1, 2)), polygon(square(1, 1, 2, 3)), false},
+		{"vertex touching an edge", polygon(square(0, 0, 1, 1)), polygon([][2]float64{{1, 0.5}, {1.5, 0.2}, {2, 0.5}, {1.5, 0.8}, {1, 0.5}}), false},
+		{"disjoint", polygon(square(0, 0, 1, 1)), polygon(square(2, 2, 3, 3)), false},
+		{"identical", polygon(square(0, 0, 1, 1)), polygon(square(0, 0, 1, 1)), true},
+		{"contained", polygon(square(0, 0, 1, 1)), polygon(square(0.4, 0.4, 0.6, 0.6)), true},
+		{"inside a hole", withHole, polygon(square(0.4, 0.4, 0.6, 0.6)), false},
+		{"filling a hole exactly", withHole, polygon(square(0.25, 0.25, 0.75, 0.75)), false},
+		{"straddling a hole edge", withHole, polygon(square(0.2, 0.4, 0.3, 0.6)), true},
+		{"diagonal strips crossing", stripNE, stripNW, true},
+		{"across the dateline", polygon(datelineSquare), polygon(square(-1, -179.5, 1, -178.5)), true},
+		{"touching across the dateline", polygon(datelineSquare), polygon(square(-1, -179, 1, -178)), false},
+		{"circles overlapping", circle(0, 0, 600), circle(0.009, 0, 600), true},
+		{"circles apart", circle(0, 0, 400), circle(0.009, 0, 400), false},
+		{"circle reaching into a square", polygon(square(0, 0, 0.01, 0.01)), circle(0.005, 0.0105, 100), true},
+		{"circle near a square", polygon(square(0, 0, 0.01, 0.01)), circle(0.005, 0.0115, 100), false},
+		{"corridor through a square", corridor(10, [2]float64{-1, 0.5}, [2]float64{2, 0.5}), polygon(square(0, 0, 1, 1)), true},
+		{"corridor beside a square", corridor(10, [2]float64{-1, 1.1}, [2]float64{2, 1.1}), polygon(square(0, 0, 1, 1)), false},
+		{"corridors crossing", corridor(10, [2]float64{0, 0}, [2]float64{1, 1}), corridor(10, [2]float64{0, 1}, [2]float64{1, 0}), true},
+		{"corridor and circle", corridor(50, [2]float64{0, 0}, [2]float64{0, 0.01}), circle(0.001, 0.005, 100), true},
+	}
+	for _, tt := range tests {
+		if got := geofencesOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: geofencesOverlap = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := geofencesOverlap(tt.b, tt.a); got != tt.want {
+			t.Errorf("%s (swapped): geofencesOverlap = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOverlapAreaM2(t *testing.T) {
+	a := &Geofence{Shape: shapePolygon, Coordinates: square(0, 0, 0.01, 0.01)}
+	b := &Geofence{Shape: shapePolygon, Coordinates: square(0.005, 0.005, 0.015, 0.015)}
+
+	want := earthRadiusM * earthRadiusM * toRadians(0.005) * (math.Sin(toRadians(0.01)) - math.Sin(toRadians(0.005)))
+	if got := overlapAreaM2(a, b); !within(got, want, 0.02) {
+		t.Errorf("overlapAreaM2 = %.0f m², want about %.0f m²", got, want)
+	}
+}
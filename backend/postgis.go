@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// postgisEnabled switches spatial lookups to PostGIS when POSTGIS=true.
+// The JSON coordinate columns remain the source of truth: every write
+// derives the geofence's geography column from them, so the mode can be
+// turned on for an existing database (the column is backfilled on startup)
+// and turned off again without losing anything.
+var postgisEnabled = os.Getenv("POSTGIS") == "true"
+
+// geofenceGeographyColumn reports whether the geofences table has the geog
+// column. It stays true after PostGIS mode is turned off, and writes then
+// clear the column so that the backfill recomputes it when the mode is
+// turned back on.
+var geofenceGeographyColumn bool
+
+const postgisSchema = `
+	CREATE EXTENSION IF NOT EXISTS postgis;
+
+	ALTER TABLE geofences ADD COLUMN IF NOT EXISTS geog geography(Geometry, 4326);
+	ALTER TABLE locations ADD COLUMN IF NOT EXISTS geog geography(Point, 4326);
+
+	CREATE INDEX IF NOT EXISTS idx_geofences_geog ON geofences USING GIST (geog);
+	CREATE INDEX IF NOT EXISTS idx_locations_geog ON locations USING GIST (geog);
+
+	CREATE OR REPLACE FUNCTION locations_set_geog() RETURNS trigger AS $$
+	BEGIN
+		NEW.geog := ST_SetSRID(ST_MakePoint(NEW.longitude, NEW.latitude), 4326)::geography;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS locations_geog ON locations;
+	CREATE TRIGGER locations_geog BEFORE INSERT OR UPDATE OF latitude, longitude ON locations
+		FOR EACH ROW EXECUTE FUNCTION locations_set_geog();
+`
+
+// pointGeography builds the geography of the point bound to lon and lat
+// parameters $1 and $2.
+const pointGeography = `ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography`
+
+func initPostGIS() {
+	if _, err := db.Exec(postgisSchema); err != nil {
+		log.Fatal("Failed to enable PostGIS mode:", err)
+	}
+
+	res, err := db.Exec(
+		`UPDATE locations SET geog = ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography
+		WHERE geog IS NULL`,
+	)
+	if err != nil {
+		log.Println("Error backfilling location points:", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Backfilled %d location points", n)
+	}
+
+	geofenceGeographyColumn = true
+	backfillGeofenceGeography()
+	log.Println("PostGIS mode enabled")
+}
+
+// detectGeofenceGeography looks for a geog column left behind by an earlier
+// run in PostGIS mode.
+func detectGeofenceGeography() {
+	err := db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'geofences' AND column_name = 'geog')`,
+	).Scan(&geofenceGeographyColumn)
+	if err != nil {
+		log.Println("Error checking for the geofence geography column:", err)
+	}
+}
+
+// backfillGeofenceGeography fills the geography column of geofences stored
+// before PostGIS mode was enabled, or edited while it was off.
+func backfillGeofenceGeography() {
+	rows, err := db.Query(
+		"SELECT " + geofenceColumns + " FROM geofences WHERE geog IS NULL AND status <> 'deleted'",
+	)
+	if err != nil {
+		log.Println("Error loading geofences for geography backfill:", err)
+		return
+	}
+
+	var geofences []Geofence
+	for rows.Next() {
+		g, err := scanGeofence(rows)
+		if err != nil {
+			log.Println("Error scanning geofence:", err)
+			continue
+		}
+		geofences = append(geofences, g)
+	}
+	rows.Close()
+
+	for i := range geofences {
+		if err := syncGeofenceGeography(db, &geofences[i]); err != nil {
+			log.Printf("Error converting geofence %s to geography: %v", geofences[i].ID, err)
+		}
+	}
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// updateGeofenceGeography keeps the geography column in step with a write
+// of the geofence: derived from the coordinates in PostGIS mode, cleared
+// when the mode is off but the column exists.
+func updateGeofenceGeography(ex execer, g *Geofence) error {
+	switch {
+	case postgisEnabled:
+		return syncGeofenceGeography(ex, g)
+	case geofenceGeographyColumn:
+		_, err := ex.Exec(`UPDATE geofences SET geog = NULL WHERE id = $1`, g.ID)
+		return err
+	}
+	return nil
+}
+
+func syncGeofenceGeography(ex execer, g *Geofence) error {
+	_, err := ex.Exec(
+		`UPDATE geofences SET geog = ST_GeogFromText($2) WHERE id = $1`,
+		g.ID, "SRID=4326;"+geofenceWKT(g),
+	)
+	return err
+}
+
+// geofenceWKT returns the geofence's geometry as WKT: the polygons for
+// polygon shapes, the center point of a circle and the path of a corridor.
+func geofenceWKT(g *Geofence) string {
+	switch g.Shape {
+	case shapeCircle:
+		if g.Center == nil {
+			return "POINT EMPTY"
+		}
+		return "POINT(" + wktPosition(*g.Center) + ")"
+	case shapeCorridor:
+		if len(g.Coordinates) == 1 {
+			return "POINT(" + wktPosition(g.Coordinates[0]) + ")"
+		}
+		return "LINESTRING" + wktPath(g.Coordinates)
+	default:
+		polygons := make([]string, 0, len(g.polygonRings()))
+		for _, rings := range g.polygonRings() {
+			parts := make([]string, len(rings))
+			for i, ring := range rings {
+				parts[i] = wktPath(ring)
+			}
+			polygons = append(polygons, "("+strings.Join(parts, ",")+")")
+		}
+		return "MULTIPOLYGON(" + strings.Join(polygons, ",") + ")"
+	}
+}
+
+func wktPath(points [][2]float64) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = wktPosition(p)
+	}
+	return "(" + strings.Join(parts, ",") + ")"
+}
+
+// wktPosition formats a [lat, lon] pair in WKT's longitude-first order.
+func wktPosition(p [2]float64) string {
+	return strconv.FormatFloat(p[1], 'f', -1, 64) + " " + strconv.FormatFloat(p[0], 'f', -1, 64)
+}
+
+// geofencesContaining returns the active geofences that contain the point,
+// from PostGIS in PostGIS mode and from the in-memory index otherwise.
+func geofencesContaining(lat, lon float64) []*Geofence {
+	if postgisEnabled {
+		if geofences, err := postgisGeofences(
+			`SELECT id FROM geofences
+			WHERE status = 'active' AND CASE shape
+				WHEN 'circle' THEN ST_DWithin(geog, `+pointGeography+`, radius_m)
+				WHEN 'corridor' THEN ST_DWithin(geog, `+pointGeography+`, width_m)
+				ELSE ST_Covers(geog, `+pointGeography+`)
+			END`,
+			lon, lat,
+		); err == nil {
+			return geofences
+		}
+	}
+	return geofenceIndex.containing(lat, lon)
+}
+
+// geofencesNear returns the active geofences that may lie within the given
+// distance of the point.
+func geofencesNear(lat, lon, meters float64) []*Geofence {
+	if postgisEnabled {
+		if geofences, err := postgisGeofences(
+			`SELECT id FROM geofences
+			WHERE status = 'active'
+			AND ST_DWithin(geog, `+pointGeography+`, $3 + COALESCE(radius_m, 0) + COALESCE(width_m, 0))`,
+			lon, lat, meters,
+		); err == nil {
+			return geofences
+		}
+	}
+	return geofenceIndex.near(lat, lon, meters)
+}
+
+// postgisGeofences runs a query selecting geofence ids and resolves them
+// through the cache. Errors are logged so callers can fall back to the
+// in-memory index.
+func postgisGeofences(query string, args ...interface{}) ([]*Geofence, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Println("Error querying PostGIS:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var geofences []*Geofence
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error querying PostGIS:", err)
+			return nil, err
+		}
+		if g, ok := geofenceIndex.get(id); ok {
+			geofences = append(geofences, g)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error querying PostGIS:", err)
+		return nil, err
+	}
+	return geofences, nil
+}
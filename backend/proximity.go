@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultNearbyRadiusM = 1000
+	maxNearbyRadiusM     = 100000
+)
+
+// locationNearbyRadiusM bounds the nearby_geofences reported with vehicle
+// locations.
+var locationNearbyRadiusM = nearbyRadiusFromEnv()
+
+func nearbyRadiusFromEnv() float64 {
+	if v := os.Getenv("NEARBY_RADIUS_M"); v != "" {
+		if r, err := strconv.ParseFloat(v, 64); err == nil && r >= 0 && r <= maxNearbyRadiusM {
+			return r
+		}
+		log.Printf("Invalid NEARBY_RADIUS_M %q, using %d", v, defaultNearbyRadiusM)
+	}
+	return defaultNearbyRadiusM
+}
+
+type NearbyGeofence struct {
+	GeofenceID   string  `json:"geofence_id"`
+	GeofenceName string  `json:"geofence_name"`
+	Category     string  `json:"category"`
+	Shape        string  `json:"shape"`
+	DistanceM    float64 `json:"distance_m"`
+	Inside       bool    `json:"inside"`
+
+	DistanceToBoundaryM float64 `json:"distance_to_boundary_m"`
+}
+
+// nearbyGeofences returns the active geofences within radiusM of the point,
+// nearest first. A geofence containing the point is at distance 0; for the
+// others the distance is that of their nearest edge.
+func nearbyGeofences(lat, lon, radiusM float64) []NearbyGeofence {
+	var result []NearbyGeofence
+	for _, g := range geofencesNear(lat, lon, radiusM) {
+		inside := g.contains(lat, lon)
+		boundary := g.distanceToBoundary(lat, lon)
+		distance := boundary
+		if inside {
+			distance = 0
+		}
+		if distance > radiusM {
+			continue
+		}
+		result = append(result, NearbyGeofence{
+			GeofenceID:          g.ID,
+			GeofenceName:        g.Name,
+			Category:            g.Category,
+			Shape:               g.Shape,
+			DistanceM:           math.Round(distance*100) / 100,
+			Inside:              inside,
+			DistanceToBoundaryM: math.Round(boundary*100) / 100,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].DistanceM < result[j].DistanceM
+	})
+	return result
+}
+
+// nearbyOutside lists the geofences in effect at the given time that lie
+// within locationNearbyRadiusM of the point without containing it.
+func nearbyOutside(lat, lon float64, at time.Time) []NearbyGeofence {
+	result := []NearbyGeofence{}
+	if locationNearbyRadiusM == 0 {
+		return result
+	}
+
+	for _, n := range nearbyGeofences(lat, lon, locationNearbyRadiusM) {
+		if n.Inside {
+			continue
+		}
+		if g, ok := geofenceIndex.get(n.GeofenceID); !ok || !g.Schedule.activeAt(at) {
+			continue
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+func getNearbyGeofences(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	q := r.URL.Query()
+
+	lat, lon, err := parseLatLon(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	radius := float64(defaultNearbyRadiusM)
+	if s := q.Get("radius_m"); s != "" {
+		radius, err = strconv.ParseFloat(s, 64)
+		if err != nil || radius <= 0 || radius > maxNearbyRadiusM {
+			http.Error(w, "radius_m must be a positive number up to 100000", http.StatusBadRequest)
+			return
+		}
+	}
+
+	geofences := []NearbyGeofence{}
+	category := q.Get("category")
+	for _, g := range nearbyGeofences(lat, lon, radius) {
+		if category == "" || g.Category == category {
+			geofences = append(geofences, g)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"geofences": geofences,
+		"count":     len(geofences),
+	}, startTime)
+}
+
+func parseLatLon(q url.Values) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return 0, 0, errors.New("lat must be a number between -90 and 90")
+	}
+	lon, err := strconv.ParseFloat(q.Get("lon"), 64)
+	if err != nil || math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return 0, 0, errors.New("lon must be a number between -180 and 180")
+	}
+	return lat, lon, nil
+}
+
+const maxContainsPoints = 1000
+
+// containingGeofences lists the geofences in effect at the given time that
+// contain the point. Unlike a location update it stores nothing and fires
+// no alerts.
+func containingGeofences(lat, lon float64, at time.Time) []CurrentGeofence {
+	geofences := checkGeofences("", lat, lon, at)
+	if geofences == nil {
+		geofences = []CurrentGeofence{}
+	}
+	return geofences
+}
+
+func getContainingGeofences(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	q := r.URL.Query()
+
+	lat, lon, err := parseLatLon(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if s := q.Get("timestamp"); s != "" {
+		if at, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			http.Error(w, "timestamp must be an ISO 8601 date-time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"latitude":  lat,
+		"longitude": lon,
+		"geofences": containingGeofences(lat, lon, at),
+	}, startTime)
+}
+
+// postContainingGeofences answers the same question for many points.
+func postContainingGeofences(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	var req struct {
+		Points []struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+			Timestamp string  `json:"timestamp"`
+		} `json:"points"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Points) == 0 || len(req.Points) > maxContainsPoints {
+		http.Error(w, fmt.Sprintf("points must contain between 1 and %d points", maxContainsPoints), http.StatusBadRequest)
+		return
+	}
+
+	var errs validationErrors
+	for i, p := range req.Points {
+		validatePoint(&errs, [2]float64{p.Latitude, p.Longitude}, fmt.Sprintf("points[%d]", i))
+		if p.Timestamp != "" {
+			if _, err := time.Parse(time.RFC3339Nano, p.Timestamp); err != nil {
+				errs.add(fmt.Sprintf("points[%d].timestamp", i), "must be an ISO 8601 date-time")
+			}
+		}
+	}
+	if len(errs) > 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "validation failed",
+			"errors": errs,
+		}, startTime)
+		return
+	}
+
+	now := time.Now()
+	results := make([]map[string]interface{}, len(req.Points))
+	for i, p := range req.Points {
+		at := now
+		if p.Timestamp != "" {
+			at = parseTimestamp(p.Timestamp)
+		}
+		results[i] = map[string]interface{}{
+			"index":     i,
+			"latitude":  p.Latitude,
+			"longitude": p.Longitude,
+			"geofences": containingGeofences(p.Latitude, p.Longitude, at),
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+	}, startTime)
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNearbyGeofences(t *testing.T) {
+	useGeofences(t,
+		&Geofence{ID: "large", Shape: shapePolygon, Coordinates: square(-1, -1, 1, 1)},
+		&Geofence{ID: "close", Shape: shapePolygon, Coordinates: square(0.002, 0, 0.003, 0.001)},
+		&Geofence{ID: "far", Shape: shapePolygon, Coordinates: square(0.1, 0, 0.2, 0.1)},
+	)
+
+	got := nearbyGeofences(0, 0.0005, 500)
+	if len(got) != 2 {
+		t.Fatalf("got %d geofences %+v, want large and close", len(got), got)
+	}
+	if got[0].GeofenceID != "large" || !got[0].Inside || got[0].DistanceM != 0 {
+		t.Errorf("first result %+v, want the containing geofence at distance 0", got[0])
+	}
+	if got[0].DistanceToBoundaryM < 100000 {
+		t.Errorf("containing geofence boundary at %v m, want its far edge", got[0].DistanceToBoundaryM)
+	}
+	if got[1].GeofenceID != "close" || got[1].Inside || got[1].DistanceM > 500 {
+		t.Errorf("second result %+v, want the geofence outside within the radius", got[1])
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// GeofenceSchedule restricts a geofence to weekly time windows in a given
+// time zone. Exceptions override the weekly windows for a whole date.
+type GeofenceSchedule struct {
+	Timezone   string              `json:"timezone"`
+	Windows    []ScheduleWindow    `json:"windows"`
+	Exceptions []ScheduleException `json:"exceptions,omitempty"`
+
+	location *time.Location
+}
+
+// UnmarshalJSON decodes the schedule and resolves its time zone once, so
+// activeAt does not load it again for every location it checks.
+func (s *GeofenceSchedule) UnmarshalJSON(b []byte) error {
+	type plain GeofenceSchedule
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	s.location, _ = time.LoadLocation(s.Timezone)
+	return nil
+}
+
+// ScheduleWindow is active from Start to End ("HH:MM") on each of Days. A
+// window whose End is not after its Start runs past midnight into the
+// following day.
+type ScheduleWindow struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// ScheduleException replaces the weekly windows on Date ("YYYY-MM-DD"). If
+// Active is false the geofence is off all day; otherwise it is on all day,
+// or only between Start and End when they are given.
+type ScheduleException struct {
+	Date   string `json:"date"`
+	Active bool   `json:"active"`
+	Start  string `json:"start,omitempty"`
+	End    string `json:"end,omitempty"`
+}
+
+// activeAt reports whether the schedule is in effect at t. A nil schedule
+// is always active.
+func (s *GeofenceSchedule) activeAt(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	loc := s.location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if ex := s.exceptionOn(local); ex != nil {
+		if !ex.Active {
+			return false
+		}
+		if ex.Start == "" && ex.End == "" {
+			return true
+		}
+		start, _ := parseClock(ex.Start)
+		end, _ := parseClock(ex.End)
+		return start <= minute && minute < end
+	}
+
+	// An exception on the previous day replaces that day's windows too,
+	// including the part of an overnight window that runs past midnight.
+	spill := s.exceptionOn(local.AddDate(0, 0, -1)) == nil
+
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s.Windows {
+		start, _ := parseClock(w.Start)
+		end, _ := parseClock(w.End)
+		overnight := end <= start
+
+		for _, day := range w.Days {
+			wd := scheduleDays[strings.ToLower(day)]
+			switch {
+			case wd == today && !overnight && start <= minute && minute < end:
+				return true
+			case wd == today && overnight && minute >= start:
+				return true
+			case wd == yesterday && overnight && spill && minute < end:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exceptionOn returns the exception for the local date of t, if any.
+func (s *GeofenceSchedule) exceptionOn(t time.Time) *ScheduleException {
+	date := t.Format("2006-01-02")
+	for i := range s.Exceptions {
+		if s.Exceptions[i].Date == date {
+			return &s.Exceptions[i]
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("time %q must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateSchedule(errs *validationErrors, s *GeofenceSchedule) {
+	if s == nil {
+		return
+	}
+
+	if s.Timezone == "" {
+		errs.add("schedule.timezone", "is required")
+	} else if loc, err := time.LoadLocation(s.Timezone); err != nil {
+		errs.add("schedule.timezone", "unknown time zone %q", s.Timezone)
+	} else {
+		s.location = loc
+	}
+	if len(s.Windows) == 0 && len(s.Exceptions) == 0 {
+		errs.add("schedule.windows", "at least one window or exception is required")
+	}
+
+	for i, w := range s.Windows {
+		field := fmt.Sprintf("schedule.windows[%d]", i)
+		if len(w.Days) == 0 {
+			errs.add(field+".days", "at least one day is required")
+		}
+		for _, day := range w.Days {
+			if _, ok := scheduleDays[strings.ToLower(day)]; !ok {
+				errs.add(field+".days", "unknown day %q (expected mon, tue, wed, thu, fri, sat, sun)", day)
+			}
+		}
+		if _, err := parseClock(w.Start); err != nil {
+			errs.add(field+".start", "%s", err.Error())
+		}
+		if _, err := parseClock(w.End); err != nil {
+			errs.add(field+".end", "%s", err.Error())
+		}
+	}
+
+	for i, ex := range s.Exceptions {
+		field := fmt.Sprintf("schedule.exceptions[%d]", i)
+		if _, err := time.Parse("2006-01-02", ex.Date); err != nil {
+			errs.add(field+".date", "date %q must be YYYY-MM-DD", ex.Date)
+		}
+		if ex.Start == "" && ex.End == "" {
+			continue
+		}
+		start, err := parseClock(ex.Start)
+		if err != nil {
+			errs.add(field+".start", "%s", err.Error())
+		}
+		end, err := parseClock(ex.End)
+		if err != nil {
+			errs.add(field+".end", "%s", err.Error())
+		}
+		if err == nil && end <= start {
+			errs.add(field, "end must be after start")
+		}
+	}
+}
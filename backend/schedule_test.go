@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleActiveAt(t *testing.T) {
+	var s GeofenceSchedule
+	err := json.Unmarshal([]byte(`{
+		"timezone": "Asia/Kolkata",
+		"windows": [
+			{"days": ["mon"], "start": "09:00", "end": "17:00"},
+			{"days": ["fri"], "start": "22:00", "end": "06:00"}
+		],
+		"exceptions": [
+			{"date": "2025-01-13", "active": true, "start": "12:00", "end": "13:00"},
+			{"date": "2025-01-17", "active": false}
+		]
+	}`), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   string
+		want bool
+	}{
+		{"monday morning in Kolkata", "2025-01-06T04:00:00Z", true},
+		{"monday before opening in Kolkata", "2025-01-06T03:00:00Z", false},
+		{"monday evening in Kolkata", "2025-01-06T11:30:00Z", false},
+		{"friday overnight window", "2025-01-10T17:00:00Z", true},
+		{"overnight window into saturday", "2025-01-11T00:00:00Z", true},
+		{"after the overnight window", "2025-01-11T01:00:00Z", false},
+		{"exception replaces monday window", "2025-01-13T04:00:00Z", false},
+		{"inside exception window", "2025-01-13T06:45:00Z", true},
+		{"friday off by exception", "2025-01-17T17:00:00Z", false},
+		{"no spill into saturday after a friday off", "2025-01-17T19:00:00Z", false},
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := s.activeAt(at); got != tt.want {
+			t.Errorf("%s: activeAt(%s) = %v, want %v", tt.name, tt.at, got, tt.want)
+		}
+	}
+
+	var none *GeofenceSchedule
+	if !none.activeAt(time.Now()) {
+		t.Error("a nil schedule should always be active")
+	}
+}
+
+func TestValidateScheduleKeepsInputInMessages(t *testing.T) {
+	s := &GeofenceSchedule{
+		Timezone: "UTC",
+		Windows:  []ScheduleWindow{{Days: []string{"mon"}, Start: "9%d", End: "17:00"}},
+	}
+
+	var errs validationErrors
+	validateSchedule(&errs, s)
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, `"9%d"`) {
+		t.Errorf("errors = %v, want one error quoting \"9%%d\"", errs)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type validationErrors []fieldError
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *validationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateGeofence checks the attributes and geometry of a geofence before
+// it is stored. With repair set, duplicate consecutive vertices are dropped
+// and rings are re-oriented (outer rings counter-clockwise, holes clockwise)
+// instead of being reported.
+func validateGeofence(g *Geofence, repair bool) error {
+	var errs validationErrors
+
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		errs.add("name", "is required")
+	}
+	if g.Category == "" {
+		errs.add("category", "is required")
+	} else if !categoryExists(g.Category) {
+		errs.add("category", "unknown category %q", g.Category)
+	}
+	if g.HysteresisM < 0 {
+		errs.add("hysteresis_m", "must not be negative")
+	}
+	validateSchedule(&errs, g.Schedule)
+	g.Tags = validateTags(&errs, g.Tags)
+	validateMetadata(&errs, g.Metadata)
+	if g.GroupID != "" && !groupExists(g.GroupID) {
+		errs.add("group_id", "unknown group %q", g.GroupID)
+	}
+	if g.Shape == "" {
+		g.Shape = shapePolygon
+	}
+
+	switch g.Shape {
+	case shapePolygon:
+		g.Coordinates = validatePolygonRing(&errs, g.Coordinates, "coordinates", false, repair)
+		for i := range g.Holes {
+			g.Holes[i] = validatePolygonRing(&errs, g.Holes[i], fmt.Sprintf("holes[%d]", i), true, repair)
+		}
+		if len(errs) == 0 {
+			validateHoles(&errs, g.Coordinates, g.Holes, "holes", 0)
+		}
+	case shapeMultiPolygon:
+		if len(g.Polygons) == 0 {
+			errs.add("polygons", "at least one polygon is required")
+		}
+		for i, rings := range g.Polygons {
+			field := fmt.Sprintf("polygons[%d]", i)
+			if len(rings) == 0 {
+				errs.add(field, "must contain an outer ring")
+				continue
+			}
+			for j := range rings {
+				rings[j] = validatePolygonRing(&errs, rings[j], fmt.Sprintf("%s[%d]", field, j), j > 0, repair)
+			}
+			if len(errs) == 0 {
+				validateHoles(&errs, rings[0], rings[1:], field, 1)
+			}
+		}
+	case shapeCircle:
+		if g.Center == nil {
+			errs.add("center", "is required for circle geofences")
+		} else {
+			validatePoint(&errs, *g.Center, "center")
+		}
+		if g.RadiusM <= 0 {
+			errs.add("radius_m", "must be greater than 0")
+		}
+	case shapeCorridor:
+		if len(g.Coordinates) < 2 {
+			errs.add("coordinates", "at least 2 path points are required")
+		}
+		for i, p := range g.Coordinates {
+			validatePoint(&errs, p, fmt.Sprintf("coordinates[%d]", i))
+		}
+		g.Coordinates = dedupeConsecutive(&errs, g.Coordinates, "coordinates", repair)
+		if g.WidthM <= 0 {
+			errs.add("width_m", "must be greater than 0")
+		}
+	default:
+		errs.add("shape", "must be one of polygon, multipolygon, circle, corridor")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validatePoint(errs *validationErrors, p [2]float64, field string) {
+	if math.IsNaN(p[0]) || p[0] < -90 || p[0] > 90 {
+		errs.add(field, "latitude %v must be between -90 and 90", p[0])
+	}
+	if math.IsNaN(p[1]) || p[1] < -180 || p[1] > 180 {
+		errs.add(field, "longitude %v must be between -180 and 180", p[1])
+	}
+}
+
+func validatePolygonRing(errs *validationErrors, ring [][2]float64, field string, hole, repair bool) [][2]float64 {
+	before := len(*errs)
+
+	if len(ring) < 4 {
+		errs.add(field, "minimum 4 points required (3 unique + 1 closing point)")
+		return ring
+	}
+	if ring[0] != ring[len(ring)-1] {
+		errs.add(field, "first and last coordinates must be identical")
+	}
+	for i, p := range ring {
+		validatePoint(errs, p, fmt.Sprintf("%s[%d]", field, i))
+	}
+	if len(*errs) > before {
+		return ring
+	}
+
+	ring = dedupeConsecutive(errs, ring, field, repair)
+	if len(*errs) > before {
+		return ring
+	}
+
+	if len(ring) < 4 {
+		errs.add(field, "fewer than 3 distinct vertices")
+		return ring
+	}
+	planar := unwrapPath(ring)
+	if planar[0] != planar[len(planar)-1] {
+		errs.add(field, "polygon encircles a pole, which is not supported")
+		return ring
+	}
+	if i, j, ok := ringSelfIntersection(planar); ok {
+		errs.add(field, "edges %d and %d intersect (self-intersecting polygon)", i, j)
+		return ring
+	}
+	area := ringSignedArea(planar)
+	if math.Abs(area) < 1e-12 {
+		errs.add(field, "polygon has zero area")
+		return ring
+	}
+
+	if repair && (area < 0) != hole {
+		ring = reverseRing(ring)
+	}
+	return ring
+}
+
+func dedupeConsecutive(errs *validationErrors, points [][2]float64, field string, repair bool) [][2]float64 {
+	out := make([][2]float64, 0, len(points))
+	for i, p := range points {
+		if i > 0 && p == points[i-1] {
+			if !repair {
+				errs.add(fmt.Sprintf("%s[%d]", field, i), "duplicates the previous vertex")
+			}
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func validateHoles(errs *validationErrors, shell [][2]float64, holes [][][2]float64, field string, firstIndex int) {
+	planar, _ := planarRings(append([][][2]float64{shell}, holes...))
+	shell = planar[0]
+
+	for i, hole := range planar[1:] {
+		inside := true
+		for _, p := range hole {
+			if !isPointInPolygon(p[0], p[1], shell) {
+				inside = false
+				break
+			}
+		}
+		if !inside || ringsCross(shell, hole) {
+			errs.add(fmt.Sprintf("%s[%d]", field, firstIndex+i), "hole must lie inside its outer ring")
+		}
+	}
+}
+
+func reverseRing(ring [][2]float64) [][2]float64 {
+	out := make([][2]float64, len(ring))
+	for i, p := range ring {
+		out[len(ring)-1-i] = p
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidatePolygonRing(t *testing.T) {
+	tests := []struct {
+		name    string
+		ring    [][2]float64
+		repair  bool
+		wantErr string
+	}{
+		{"valid", square(0, 0, 1, 1), false, ""},
+		{"too few points", [][2]float64{{0, 0}, {0, 1}, {0, 0}}, false, "minimum 4 points"},
+		{"not closed", [][2]float64{{0, 0}, {0, 1}, {1, 1}, {1, 0}}, false, "must be identical"},
+		{"latitude out of range", square(0, 0, 91, 1), false, "latitude 91"},
+		{"longitude out of range", square(0, 0, 1, 181), false, "longitude 181"},
+		{"bow-tie", [][2]float64{{0, 0}, {1, 1}, {1, 0}, {0, 1}, {0, 0}}, false, "intersect"},
+		{"duplicate vertex", [][2]float64{{0, 0}, {0, 1}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}, false, "duplicates the previous vertex"},
+		{"duplicate vertex repaired", [][2]float64{{0, 0}, {0, 1}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}, true, ""},
+		{"collinear", [][2]float64{{0, 0}, {0, 1}, {0, 2}, {0, 0}}, false, "zero area"},
+		{"too few distinct vertices", [][2]float64{{0, 0}, {0, 1}, {0, 1}, {0, 0}}, true, "fewer than 3 distinct vertices"},
+	}
+	for _, tt := range tests {
+		var errs validationErrors
+		validatePolygonRing(&errs, tt.ring, "coordinates", false, tt.repair)
+		if tt.wantErr == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: unexpected errors %v", tt.name, errs)
+			}
+			continue
+		}
+		if !strings.Contains(errs.Error(), tt.wantErr) {
+			t.Errorf("%s: errors %q do not mention %q", tt.name, errs.Error(), tt.wantErr)
+		}
+	}
+}
+
+func TestValidatePolygonRingRepairsWinding(t *testing.T) {
+	clockwise := reverseRing(square(0, 0, 1, 1))
+
+	tests := []struct {
+		name     string
+		ring     [][2]float64
+		hole     bool
+		wantArea float64
+	}{
+		{"clockwise shell", clockwise, false, 1},
+		{"counter-clockwise shell", square(0, 0, 1, 1), false, 1},
+		{"counter-clockwise hole", square(0, 0, 1, 1), true, -1},
+	}
+	for _, tt := range tests {
+		var errs validationErrors
+		ring := validatePolygonRing(&errs, tt.ring, "coordinates", tt.hole, true)
+		if len(errs) > 0 {
+			t.Fatalf("%s: unexpected errors %v", tt.name, errs)
+		}
+		if got := math.Copysign(1, ringSignedArea(ring)); got != tt.wantArea {
+			t.Errorf("%s: orientation sign = %v, want %v", tt.name, got, tt.wantArea)
+		}
+	}
+}
+
+func TestValidateGeofenceFieldErrors(t *testing.T) {
+	g := &Geofence{Name: "  ", Shape: "triangle", HysteresisM: -1}
+	err := validateGeofence(g, false)
+
+	errs, ok := err.(validationErrors)
+	if !ok {
+		t.Fatalf("validateGeofence returned %v, want validationErrors", err)
+	}
+	fields := make(map[string]bool)
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	for _, field := range []string{"name", "category", "hysteresis_m", "shape"} {
+		if !fields[field] {
+			t.Errorf("missing error for %s in %v", field, errs)
+		}
+	}
+}
+
+func TestValidatePolygonRingAcrossDateline(t *testing.T) {
+	tests := []struct {
+		name    string
+		ring    [][2]float64
+		wantErr string
+	}{
+		{"dateline crossing", datelineSquare, ""},
+		{"encircles the north pole", [][2]float64{{80, 0}, {80, 90}, {80, 180}, {80, -90}, {80, 0}}, "encircles a pole"},
+		{"encircles the south pole", [][2]float64{{-80, 0}, {-80, -90}, {-80, 180}, {-80, 90}, {-80, 0}}, "encircles a pole"},
+	}
+	for _, tt := range tests {
+		var errs validationErrors
+		validatePolygonRing(&errs, tt.ring, "coordinates", false, true)
+		if tt.wantErr == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: unexpected errors %v", tt.name, errs)
+			}
+			continue
+		}
+		if !strings.Contains(errs.Error(), tt.wantErr) {
+			t.Errorf("%s: errors %q do not mention %q", tt.name, errs.Error(), tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxEvaluatedLocations = 5000
+
+// A geofenceVersion is a snapshot of a geofence's definition together with
+// the interval during which it was in effect. valid_to is nil for the
+// current version.
+type geofenceVersion struct {
+	Version   int        `json:"version"`
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+	Geofence  Geofence   `json:"geofence"`
+}
+
+// recordGeofenceVersion closes the geofence's current version and records
+// its new definition as the next one. It runs in the transaction that
+// writes the geofence row, whose row lock serializes concurrent edits, so
+// two writers cannot both claim the same version number.
+func recordGeofenceVersion(ex execer, g *Geofence, at time.Time) error {
+	definition, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	if err := closeGeofenceVersion(ex, g.ID, at); err != nil {
+		return err
+	}
+
+	_, err = ex.Exec(
+		`INSERT INTO geofence_versions (geofence_id, version, definition, valid_from)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3
+		FROM geofence_versions WHERE geofence_id = $1`,
+		g.ID, string(definition), sqlTimestamp(at),
+	)
+	return err
+}
+
+func closeGeofenceVersion(ex execer, geofenceID string, at time.Time) error {
+	_, err := ex.Exec(
+		`UPDATE geofence_versions SET valid_to = $2
+		WHERE geofence_id = $1 AND valid_to IS NULL`,
+		geofenceID, sqlTimestamp(at),
+	)
+	return err
+}
+
+// backfillGeofenceVersions gives geofences created before versioning
+// existed a first version, valid from their creation time.
+func backfillGeofenceVersions() {
+	rows, err := db.Query(
+		"SELECT " + geofenceColumns + ` FROM geofences g
+		WHERE NOT EXISTS (SELECT 1 FROM geofence_versions v WHERE v.geofence_id = g.id)`,
+	)
+	if err != nil {
+		log.Println("Error querying unversioned geofences:", err)
+		return
+	}
+
+	var pending []Geofence
+	for rows.Next() {
+		g, err := scanGeofence(rows)
+		if err != nil {
+			log.Println("Error scanning geofence:", err)
+			continue
+		}
+		pending = append(pending, g)
+	}
+	rows.Close()
+
+	for i := range pending {
+		g := &pending[i]
+		createdAt, err := time.Parse(time.RFC3339Nano, g.CreatedAt)
+		if err != nil {
+			createdAt = time.Now()
+		}
+		if err := recordGeofenceVersion(db, g, createdAt); err != nil {
+			log.Println("Error recording geofence version:", err)
+			continue
+		}
+		if g.Status == "deleted" {
+			if err := closeGeofenceVersion(db, g.ID, time.Now()); err != nil {
+				log.Println("Error closing geofence version:", err)
+			}
+		}
+	}
+}
+
+func loadGeofenceVersions(geofenceID string) ([]geofenceVersion, error) {
+	rows, err := db.Query(
+		`SELECT version, definition, valid_from, valid_to FROM geofence_versions
+		WHERE geofence_id = $1 ORDER BY version`,
+		geofenceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []geofenceVersion
+	for rows.Next() {
+		var v geofenceVersion
+		var definition string
+		var validTo sql.NullTime
+		if err := rows.Scan(&v.Version, &definition, &v.ValidFrom, &validTo); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(definition), &v.Geofence); err != nil {
+			return nil, fmt.Errorf("geofence %s version %d: %w", geofenceID, v.Version, err)
+		}
+		if validTo.Valid {
+			t := validTo.Time
+			v.ValidTo = &t
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// versionAt returns the version in effect at t, or nil if the geofence did
+// not exist (or had been deleted) at that time.
+func versionAt(versions []geofenceVersion, t time.Time) *geofenceVersion {
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].ValidFrom.After(t)
+	})
+	if i == 0 {
+		return nil
+	}
+
+	v := &versions[i-1]
+	if v.ValidTo != nil && !t.Before(*v.ValidTo) {
+		return nil
+	}
+	return v
+}
+
+func getGeofenceVersions(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	geofenceID := chi.URLParam(r, "geofenceID")
+
+	versions, err := loadGeofenceVersions(geofenceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"geofence_id": geofenceID,
+		"versions":    versions,
+	}, startTime)
+}
+
+// evaluateGeofenceHistory replays a vehicle's stored locations against the
+// geofence, using for each location the version of the boundary that was
+// in effect at its timestamp.
+func evaluateGeofenceHistory(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	geofenceID := chi.URLParam(r, "geofenceID")
+	vehicleID := r.URL.Query().Get("vehicle_id")
+	startDate := r.URL.Query().Get("start_date")
+	endDate := r.URL.Query().Get("end_date")
+
+	if vehicleID == "" {
+		http.Error(w, "vehicle_id is required", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := loadGeofenceVersions(geofenceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+
+	query := `SELECT latitude, longitude, timestamp FROM locations WHERE vehicle_id = $1`
+	args := []interface{}{vehicleID}
+	if startDate != "" {
+		args = append(args, startDate)
+		query += fmt.Sprintf(" AND timestamp >= $%d", len(args))
+	}
+	if endDate != "" {
+		args = append(args, endDate)
+		query += fmt.Sprintf(" AND timestamp <= $%d", len(args))
+	}
+	args = append(args, maxEvaluatedLocations)
+	query += fmt.Sprintf(" ORDER BY timestamp LIMIT $%d", len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	evaluations := []map[string]interface{}{}
+	events := []map[string]interface{}{}
+	inside := false
+	for rows.Next() {
+		var lat, lon float64
+		var ts time.Time
+		if err := rows.Scan(&lat, &lon, &ts); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		entry := map[string]interface{}{
+			"latitude":  lat,
+			"longitude": lon,
+			"timestamp": ts.Format(time.RFC3339),
+		}
+
+		wasInside := inside
+		if v := versionAt(versions, ts); v != nil && v.Geofence.Status == "active" {
+			if v.Geofence.Schedule.activeAt(ts) {
+				inside = v.Geofence.insideWithHysteresis(lat, lon, wasInside)
+			} else {
+				entry["scheduled_off"] = true
+			}
+			entry["geofence_version"] = v.Version
+		} else {
+			inside = false
+		}
+		entry["inside"] = inside
+
+		if inside != wasInside {
+			eventType := "exit"
+			if inside {
+				eventType = "entry"
+			}
+			event := map[string]interface{}{
+				"event_type": eventType,
+				"latitude":   lat,
+				"longitude":  lon,
+				"timestamp":  ts.Format(time.RFC3339),
+			}
+			if version, ok := entry["geofence_version"]; ok {
+				event["geofence_version"] = version
+			}
+			events = append(events, event)
+		}
+		evaluations = append(evaluations, entry)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"geofence_id": geofenceID,
+		"vehicle_id":  vehicleID,
+		"evaluations": evaluations,
+		"events":      events,
+	}, startTime)
+}